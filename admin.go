@@ -0,0 +1,130 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sessionStatus 是 /admin/sessions 中的一个会话
+type sessionStatus struct {
+	ID              string    `json:"id"`
+	Route           string    `json:"route"`
+	Upstream        string    `json:"upstream"`
+	UpstreamSession string    `json:"upstream_session"`
+	User            string    `json:"user"`
+	ClientIP        string    `json:"client_ip"`
+	ClientName      string    `json:"client_name,omitempty"`
+	ClientVersion   string    `json:"client_version,omitempty"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	Started         time.Time `json:"started"`
+	Connected       bool      `json:"connected"`
+	InFlight        int       `json:"in_flight"`
+	BytesIn         int64     `json:"bytes_in"`
+	BytesOut        int64     `json:"bytes_out"`
+	Requests        int64     `json:"requests"`
+	ToolCalls       int64     `json:"tool_calls"`
+}
+
+// status 汇总会话当前状态，客户端信息取自 initialize 请求
+func (s *mcpSession) status() sessionStatus {
+	s.mu.Lock()
+	st := sessionStatus{
+		ID:              s.ID,
+		Route:           s.Prefix,
+		Upstream:        s.Upstream,
+		UpstreamSession: s.UpstreamID,
+		User:            s.User,
+		ClientIP:        s.ClientIP,
+		Started:         s.Started,
+		Connected:       s.attached != 0,
+	}
+	initialize := s.initialize
+	s.mu.Unlock()
+
+	var params struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ClientInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"clientInfo"`
+	}
+	if initialize != nil && json.Unmarshal(initialize, &params) == nil {
+		st.ClientName = params.ClientInfo.Name
+		st.ClientVersion = params.ClientInfo.Version
+		st.ProtocolVersion = params.ProtocolVersion
+	}
+	st.InFlight = s.inFlightCalls()
+	st.BytesIn = s.bytesIn.Load()
+	st.BytesOut = s.bytesOut.Load()
+	st.Requests = s.requests.Load()
+	st.ToolCalls = s.toolCalls.Load()
+	return st
+}
+
+// AdminSessions 管理活动会话：GET 列出会话（可按 route 过滤），DELETE 按 id 强制结束会话
+func AdminSessions(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	if route != "" {
+		route = "/" + strings.Trim(route, "/")
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions := sessionsSnapshot(route)
+		result := make([]sessionStatus, 0, len(sessions))
+		for _, s := range sessions {
+			result = append(result, s.status())
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Started.Before(result[j].Started) })
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			writeError(w, r, kindBadRequest, "missing id parameter", nil)
+			return
+		}
+		var session *mcpSession
+		for _, s := range sessionsSnapshot(route) {
+			if s.ID == id {
+				session = s
+				break
+			}
+		}
+		if session == nil {
+			writeError(w, r, kindNotFound, "unknown session", map[string]any{"session": id})
+			return
+		}
+
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "session terminated by gateway administrator"
+		}
+		st := session.status()
+		session.kill(reason)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	default:
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// AdminDashboard 返回会话管理页面，页面本身不含数据，会话信息由页面携带管理 token 请求 /admin/sessions 获取
+func AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(dashboardHTML)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminSessions(t *testing.T, method, query string) (int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	AdminSessions(rec, httptest.NewRequest(method, "/admin/sessions?"+query, nil))
+	return rec.Code, rec.Body.String()
+}
+
+func TestAdminSessions(t *testing.T) {
+	resetRoutes(t)
+	sseTestConfig(t)
+	upstream := newFakeUpstream(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	upstream.handle = func(msg *jsonrpcMessage) any {
+		if msg.Method == "tools/call" {
+			<-release
+		}
+		return map[string]any{}
+	}
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	c, id := openSession(t, gw.URL, nil)
+	if code, body := c.post(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"web_search","arguments":{}}}`, nil); code != http.StatusAccepted {
+		t.Fatalf("tools/call: %d %s", code, body)
+	}
+
+	// 列表中带有 initialize 的客户端信息与未完成的调用
+	code, body := adminSessions(t, http.MethodGet, "route=search")
+	var list []sessionStatus
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &list) != nil || len(list) != 1 {
+		t.Fatalf("GET: %d %s", code, body)
+	}
+	st := list[0]
+	if st.ID != id || st.Route != "/search" || st.ClientName != "test" || st.ProtocolVersion != "2024-11-05" ||
+		!st.Connected || st.InFlight != 1 || st.ToolCalls != 1 || st.Requests != 2 || st.BytesIn == 0 || st.BytesOut == 0 {
+		t.Errorf("session status = %+v", st)
+	}
+	if _, body := adminSessions(t, http.MethodGet, "route=other"); strings.TrimSpace(body) != "[]" {
+		t.Errorf("other route sessions = %s", body)
+	}
+
+	if code, _ := adminSessions(t, http.MethodDelete, ""); code != http.StatusBadRequest {
+		t.Errorf("DELETE without id: %d", code)
+	}
+	if code, _ := adminSessions(t, http.MethodDelete, "id=missing"); code != http.StatusNotFound {
+		t.Errorf("DELETE unknown id: %d", code)
+	}
+
+	// 强制结束：后端收到取消通知，客户端收到原因后流结束
+	if code, body := adminSessions(t, http.MethodDelete, "id="+id+"&reason=maintenance"); code != http.StatusOK {
+		t.Fatalf("DELETE: %d %s", code, body)
+	}
+	msg := c.nextMessage(5 * time.Second)
+	if msg.Method != "notifications/message" || !strings.Contains(string(msg.Params), "maintenance") {
+		t.Errorf("client notification = %+v", msg)
+	}
+	if _, ok := c.next(5 * time.Second); ok {
+		t.Error("client stream still open after termination")
+	}
+	if got := upstream.methods(); got[len(got)-1] != "notifications/cancelled" {
+		t.Errorf("upstream received %v", got)
+	}
+	if lookupSession("/search", id) != nil {
+		t.Error("terminated session still registered")
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// auditRecord 是审计日志中的一行，对应一次完成的 tools/call
+type auditRecord struct {
+	Time       time.Time       `json:"time"`
+	User       string          `json:"user"`
+	ClientIP   string          `json:"client_ip,omitempty"`
+	Route      string          `json:"route"`
+	Session    string          `json:"session"`
+	RequestID  json.RawMessage `json:"request_id"`
+	Tool       string          `json:"tool"`
+	Args       map[string]any  `json:"args,omitempty"`
+	ResultSize int             `json:"result_size"`
+	IsError    bool            `json:"is_error"`
+	LatencyMs  int64           `json:"latency_ms"`
+	Downgraded string          `json:"downgraded_to,omitempty"` // 超出配额后改由该路由完成
+}
+
+// redactRule 描述一个字段脱敏规则，格式为 tool.path.to.field[:action]
+// tool 为 * 时匹配所有工具，action 可选 redact（默认）、hash、drop
+type redactRule struct {
+	tool   string
+	path   []string
+	action string
+}
+
+// auditLogger 将审计记录以 JSON Lines 写入滚动文件
+type auditLogger struct {
+	mu    sync.Mutex
+	out   *rotatingFile
+	rules []redactRule
+}
+
+// auditor 存储当前的审计日志，为 nil 时审计关闭；配置重载时整体替换
+var auditor atomic.Pointer[auditLogger]
+
+// newAuditLogger 根据配置创建审计日志，未配置文件时返回 nil
+func newAuditLogger(cfg AuditConfig) (*auditLogger, error) {
+	if cfg.File == "" {
+		return nil, nil
+	}
+
+	rules, err := parseRedactRules(cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := openRotatingFile(cfg.File, int64(cfg.MaxSizeMB)*1024*1024, cfg.MaxBackups)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auditLogger{out: out, rules: rules}, nil
+}
+
+func parseRedactRules(spec []string) ([]redactRule, error) {
+	var rules []redactRule
+	for _, item := range spec {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		rule := redactRule{action: "redact"}
+		if field, action, ok := strings.Cut(item, ":"); ok {
+			item, rule.action = field, action
+		}
+		switch rule.action {
+		case "redact", "hash", "drop":
+		default:
+			return nil, fmt.Errorf("rule %q: unsupported action %q", item, rule.action)
+		}
+
+		parts := strings.Split(item, ".")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("rule %q: expected tool.field", item)
+		}
+		rule.tool, rule.path = parts[0], parts[1:]
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// record 写入一条审计记录，session 或 call 缺失时忽略
+func (a *auditLogger) record(s *mcpSession, call *pendingCall, resp *jsonrpcMessage, resultSize int) {
+	if a == nil || s == nil || call == nil || call.Method != "tools/call" {
+		return
+	}
+
+	rec := auditRecord{
+		Time:       call.Started,
+		User:       s.User,
+		ClientIP:   s.ClientIP,
+		Route:      s.Prefix,
+		Session:    s.ID,
+		RequestID:  call.ID,
+		Tool:       call.Tool,
+		Args:       a.redact(call.Tool, call.Args),
+		ResultSize: resultSize,
+		IsError:    resp.isToolError(),
+		LatencyMs:  time.Since(call.Started).Milliseconds(),
+		Downgraded: call.downgradeTo,
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		s.log.Error("failed to encode audit record", "error", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out == nil {
+		return
+	}
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		s.log.Error("failed to write audit record", "error", err)
+	}
+}
+
+// Close 关闭审计文件，之后的记录被丢弃
+func (a *auditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out == nil {
+		return nil
+	}
+	err := a.out.file.Close()
+	a.out = nil
+	return err
+}
+
+// redact 返回按规则脱敏后的参数副本，不修改原参数
+func (a *auditLogger) redact(tool string, args map[string]any) map[string]any {
+	if a == nil || len(args) == 0 || len(a.rules) == 0 {
+		return args
+	}
+
+	copied, _ := deepCopyJSON(args).(map[string]any)
+	for _, rule := range a.rules {
+		if rule.tool != "*" && rule.tool != tool {
+			continue
+		}
+		applyRedactRule(copied, rule.path, rule.action)
+	}
+	return copied
+}
+
+func applyRedactRule(obj map[string]any, path []string, action string) {
+	value, ok := obj[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) > 1 {
+		if child, ok := value.(map[string]any); ok {
+			applyRedactRule(child, path[1:], action)
+		}
+		return
+	}
+
+	switch action {
+	case "drop":
+		delete(obj, path[0])
+	case "hash":
+		raw, _ := json.Marshal(value)
+		sum := sha256.Sum256(raw)
+		obj[path[0]] = "sha256:" + hex.EncodeToString(sum[:])
+	default:
+		obj[path[0]] = "[REDACTED]"
+	}
+}
+
+// deepCopyJSON 复制 encoding/json 解码得到的值
+func deepCopyJSON(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(val))
+		for k, item := range val {
+			copied[k] = deepCopyJSON(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(val))
+		for i, item := range val {
+			copied[i] = deepCopyJSON(item)
+		}
+		return copied
+	default:
+		return val
+	}
+}
+
+// rotatingFile 是按大小滚动的文件写入器，备份文件命名为 path.1 ... path.N
+type rotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write 写入数据，写入后超过上限时先滚动文件；调用方负责加锁
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+
+	return f.open()
+}
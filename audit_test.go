@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditRedact(t *testing.T) {
+	rules, err := parseRedactRules([]string{"*.password", "web_search.auth.token:hash", "web_search.debug:drop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	out, err := openRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &auditLogger{out: out, rules: rules}
+
+	args := map[string]any{
+		"query":    "mcp",
+		"password": "hunter2",
+		"auth":     map[string]any{"token": "secret", "scheme": "bearer"},
+		"debug":    true,
+	}
+	s := &mcpSession{ID: "s1", Prefix: "/search", User: "alice"}
+	call := &pendingCall{ID: json.RawMessage(`7`), Method: "tools/call", Tool: "web_search", Args: args, Started: time.Now()}
+	a.record(s, call, &jsonrpcMessage{Result: json.RawMessage(`{"content":[],"isError":true}`)}, 42)
+	// 其他工具只应用通配规则
+	call = &pendingCall{ID: json.RawMessage(`8`), Method: "tools/call", Tool: "fetch", Args: args, Started: time.Now()}
+	a.record(s, call, &jsonrpcMessage{Result: json.RawMessage(`{"content":[]}`)}, 1)
+	// 不是工具调用的请求不写入审计
+	a.record(s, &pendingCall{ID: json.RawMessage(`9`), Method: "tools/list", Started: time.Now()}, &jsonrpcMessage{}, 1)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %d audit records, want 2:\n%s", len(lines), data)
+	}
+	var search, fetch auditRecord
+	json.Unmarshal(lines[0], &search)
+	json.Unmarshal(lines[1], &fetch)
+
+	if search.User != "alice" || search.Tool != "web_search" || !search.IsError || search.ResultSize != 42 {
+		t.Errorf("record = %+v", search)
+	}
+	if search.Args["password"] != "[REDACTED]" || search.Args["query"] != "mcp" {
+		t.Errorf("web_search args = %v", search.Args)
+	}
+	if token, _ := search.Args["auth"].(map[string]any)["token"].(string); !strings.HasPrefix(token, "sha256:") {
+		t.Errorf("auth.token = %q, want hashed", token)
+	}
+	if _, ok := search.Args["debug"]; ok {
+		t.Error("debug not dropped")
+	}
+	if fetch.Args["password"] != "[REDACTED]" || fetch.Args["debug"] != true {
+		t.Errorf("fetch args = %v", fetch.Args)
+	}
+	// 脱敏作用于副本，不修改转发的参数
+	if args["password"] != "hunter2" || args["auth"].(map[string]any)["token"] != "secret" {
+		t.Errorf("original args modified: %v", args)
+	}
+
+	for _, spec := range []string{"password", "*.password:mask"} {
+		if _, err := parseRedactRules([]string{spec}); err == nil {
+			t.Errorf("parseRedactRules(%q) accepted", spec)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(strings.Repeat("x", 39) + "\n")
+	for range 8 {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.file.Close()
+
+	// 每个文件放下两行，超出上限前滚动；只保留两个备份
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != 80 {
+			t.Errorf("%s: %d bytes, want 80", filepath.Base(name), info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want at most 2 backups", path)
+	}
+
+	// 重新打开时从已有大小继续计算
+	f, err = openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.file.Close()
+	if f.size != 80 {
+		t.Errorf("reopened size = %d, want 80", f.size)
+	}
+}
+
+func TestRequestUser(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.trustedNets = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	useConfig(t, cfg)
+
+	tests := []struct {
+		name, remote, header, basic string
+		key                         *APIKeyConfig
+		want                        string
+	}{
+		{"trusted proxy header", "10.0.0.5:5000", "alice", "", nil, "alice"},
+		{"trusted proxy basic auth", "10.0.0.5:5000", "", "bob", nil, "bob"},
+		// 直连的客户端不能通过头部冒充其他用户
+		{"untrusted header", "192.0.2.1:5000", "alice", "", nil, "anonymous"},
+		{"untrusted basic auth", "192.0.2.1:5000", "", "bob", nil, "anonymous"},
+		{"api key wins", "10.0.0.5:5000", "alice", "", &APIKeyConfig{Key: "k", User: "carol"}, "carol"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/search/sse", nil)
+		r.RemoteAddr = tt.remote
+		if tt.header != "" {
+			r.Header.Set("X-Forwarded-User", tt.header)
+		}
+		if tt.basic != "" {
+			r.SetBasicAuth(tt.basic, "password")
+		}
+		if tt.key != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyKey, tt.key))
+		}
+		if got := requestUser(r); got != tt.want {
+			t.Errorf("%s: user = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
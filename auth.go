@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+const apiKeyKey contextKey = "apiKey"
+
+// requestAPIKey 从 Authorization: Bearer、X-API-Key 头或 api_key 查询参数中取出 API Key
+// 查询参数用于只能配置 URL 的 SSE 客户端
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// stripAPIKey 删除请求中的网关 API Key，避免转发给后端；不是网关 API Key 的凭据（如后端自己的 token）原样保留
+func stripAPIKey(r *http.Request, cfg *Config) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") && cfg.lookupAPIKey(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))) != nil {
+		r.Header.Del("Authorization")
+	}
+	if cfg.lookupAPIKey(r.Header.Get("X-API-Key")) != nil {
+		r.Header.Del("X-API-Key")
+	}
+	if query := r.URL.Query(); cfg.lookupAPIKey(query.Get("api_key")) != nil {
+		query.Del("api_key")
+		r.URL.RawQuery = query.Encode()
+	}
+}
+
+// apiKeyFrom 取出请求上下文中已认证的 API Key
+func apiKeyFrom(ctx context.Context) *APIKeyConfig {
+	key, _ := ctx.Value(apiKeyKey).(*APIKeyConfig)
+	return key
+}
+
+// 认证中间件：识别 API Key，路由策略要求认证时拒绝未认证请求
+func authMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := config()
+		prefix, _ := r.Context().Value(prefixKey).(string)
+
+		key := cfg.lookupAPIKey(requestAPIKey(r))
+		// message 端点的请求沿用所属会话建立时的认证，携带的 API Key 必须与会话的一致，不能使用其他租户的会话
+		if s := lookupSession(prefix, r.URL.Query().Get("sessionId")); s != nil {
+			switch {
+			case key == nil:
+				key = s.APIKey
+			case s.APIKey == nil || s.APIKey.Key != key.Key:
+				loggerFrom(r.Context()).Warn("api key does not match session", "session", s.ID, "user", key.User)
+				writeError(w, r, kindForbidden, "API key does not match the session", nil)
+				return
+			}
+		}
+
+		if key != nil {
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyKey, key))
+		} else if policy := cfg.policyFor(prefix); policy.RequireAuth != nil && *policy.RequireAuth {
+			loggerFrom(r.Context()).Warn("unauthorized request", "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcp-gateway"`)
+			writeError(w, r, kindUnauthorized, "authentication required, provide a valid API key", nil)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func authConfig(t *testing.T) *Config {
+	cfg := defaultConfig()
+	cfg.Auth.APIKeys = []APIKeyConfig{
+		{Key: "key-a", User: "alice", Tenant: "acme"},
+		{Key: "key-b", User: "bob", Tenant: "globex"},
+	}
+	useConfig(t, cfg)
+	return cfg
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	cfg := authConfig(t)
+	required := true
+	cfg.Routes = []RouteConfig{{Name: "private", Prefix: "/private", Policies: PolicyConfig{RequireAuth: &required}}}
+
+	tests := []struct {
+		name   string
+		prefix string
+		setup  func(r *http.Request)
+		status int
+		user   string
+	}{
+		{"bearer", "/private", func(r *http.Request) { r.Header.Set("Authorization", "Bearer key-a") }, http.StatusOK, "alice"},
+		{"header", "/private", func(r *http.Request) { r.Header.Set("X-API-Key", "key-b") }, http.StatusOK, "bob"},
+		{"query", "/private", func(r *http.Request) { r.URL.RawQuery = "api_key=key-a" }, http.StatusOK, "alice"},
+		{"missing", "/private", func(r *http.Request) {}, http.StatusUnauthorized, ""},
+		{"unknown key", "/private", func(r *http.Request) { r.Header.Set("X-API-Key", "nope") }, http.StatusUnauthorized, ""},
+		{"public route", "/public", func(r *http.Request) {}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		var user string
+		handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := apiKeyFrom(r.Context()); key != nil {
+				user = key.User
+			}
+		}))
+		r := httptest.NewRequest(http.MethodGet, tt.prefix+"/sse", nil)
+		r = r.WithContext(context.WithValue(r.Context(), prefixKey, tt.prefix))
+		tt.setup(r)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.status || user != tt.user {
+			t.Errorf("%s: status %d user %q, want %d %q", tt.name, rec.Code, user, tt.status, tt.user)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: missing WWW-Authenticate", tt.name)
+		}
+	}
+}
+
+func TestStripAPIKey(t *testing.T) {
+	cfg := authConfig(t)
+
+	r := httptest.NewRequest(http.MethodGet, "/sse?api_key=key-a&foo=1", nil)
+	r.Header.Set("Authorization", "Bearer key-a")
+	r.Header.Set("X-API-Key", "key-b")
+	stripAPIKey(r, cfg)
+	if r.Header.Get("Authorization") != "" || r.Header.Get("X-API-Key") != "" {
+		t.Errorf("gateway key forwarded in headers: %v", r.Header)
+	}
+	if got := r.URL.RawQuery; got != "foo=1" {
+		t.Errorf("query = %q, want foo=1", got)
+	}
+
+	// 不是网关 API Key 的凭据转发给后端
+	r = httptest.NewRequest(http.MethodGet, "/sse?api_key=upstream", nil)
+	r.Header.Set("Authorization", "Bearer upstream-token")
+	stripAPIKey(r, cfg)
+	if r.Header.Get("Authorization") != "Bearer upstream-token" || r.URL.RawQuery != "api_key=upstream" {
+		t.Errorf("upstream credentials removed: %v %q", r.Header, r.URL.RawQuery)
+	}
+}
+
+func TestAuthSessionKeyMismatch(t *testing.T) {
+	cfg := authConfig(t)
+	s := &mcpSession{ID: "s1", Prefix: "/search", APIKey: &cfg.Auth.APIKeys[0]}
+	sessionMapLock.Lock()
+	sessionMap[sessionMapKey(s.Prefix, s.ID)] = s
+	sessionMapLock.Unlock()
+	t.Cleanup(func() {
+		sessionMapLock.Lock()
+		delete(sessionMap, sessionMapKey(s.Prefix, s.ID))
+		sessionMapLock.Unlock()
+	})
+
+	tests := []struct {
+		name   string
+		key    string
+		status int
+		user   string
+	}{
+		{"session key", "key-a", http.StatusOK, "alice"},
+		{"no key uses session", "", http.StatusOK, "alice"},
+		{"other tenant key", "key-b", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		var user string
+		handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = apiKeyFrom(r.Context()).User
+		}))
+		r := httptest.NewRequest(http.MethodPost, "/message?sessionId=s1", nil)
+		r = r.WithContext(context.WithValue(r.Context(), prefixKey, "/search"))
+		if tt.key != "" {
+			r.Header.Set("X-API-Key", tt.key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.status || user != tt.user {
+			t.Errorf("%s: status %d user %q, want %d %q", tt.name, rec.Code, user, tt.status, tt.user)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_gateway_circuit_state",
+		Help: "Circuit breaker state per upstream (0 closed, 1 half-open, 2 open).",
+	}, []string{"upstream"})
+
+	circuitRejectedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_circuit_rejected_total",
+		Help: "Upstream requests rejected without being sent because the circuit was open.",
+	}, []string{"upstream"})
+
+	upstreamRetriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_upstream_retries_total",
+		Help: "Retried upstream requests.",
+	}, []string{"upstream"})
+)
+
+// errCircuitOpen 表示后端处于熔断状态，请求没有发出
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+// 熔断器状态
+const (
+	circuitClosed   = "closed"
+	circuitHalfOpen = "half-open"
+	circuitOpen     = "open"
+)
+
+// circuitBreaker 是一个后端的熔断器：连续失败达到阈值后熔断，
+// 熔断 open_timeout 后进入半开状态并只放行一个探测请求，探测成功则恢复，失败则重新熔断
+type circuitBreaker struct {
+	upstream string
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// 熔断器表，键为后端的 scheme://host，多个路由指向同一后端时共享
+var (
+	breakers     = map[string]*circuitBreaker{}
+	breakersLock sync.Mutex
+)
+
+// upstreamOrigin 返回后端地址的 scheme://host
+func upstreamOrigin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// breakerFor 返回后端对应的熔断器
+func breakerFor(origin string) *circuitBreaker {
+	breakersLock.Lock()
+	defer breakersLock.Unlock()
+	b, ok := breakers[origin]
+	if !ok {
+		b = &circuitBreaker{upstream: origin, state: circuitClosed}
+		breakers[origin] = b
+		circuitState.WithLabelValues(origin).Set(0)
+	}
+	return b
+}
+
+// circuitStatus 返回目标地址所在后端的熔断状态与熔断结束时间，没有熔断器时为 closed
+func circuitStatus(target string) (string, time.Time) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return circuitClosed, time.Time{}
+	}
+	breakersLock.Lock()
+	b, ok := breakers[upstreamOrigin(u)]
+	breakersLock.Unlock()
+	if !ok {
+		return circuitClosed, time.Time{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == circuitOpen {
+		return b.state, b.openedAt.Add(config().Resilience.CircuitBreaker.OpenTimeout)
+	}
+	return b.state, time.Time{}
+}
+
+// setState 切换状态并更新指标，调用方需持有 mu
+func (b *circuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	if state == circuitClosed {
+		logger.Info("circuit breaker state changed", "upstream", b.upstream, "from", b.state, "to", state)
+	} else {
+		logger.Warn("circuit breaker state changed", "upstream", b.upstream, "from", b.state, "to", state)
+	}
+	b.state = state
+	switch state {
+	case circuitClosed:
+		circuitState.WithLabelValues(b.upstream).Set(0)
+	case circuitHalfOpen:
+		circuitState.WithLabelValues(b.upstream).Set(1)
+	case circuitOpen:
+		circuitState.WithLabelValues(b.upstream).Set(2)
+	}
+}
+
+// allow 判断是否可以发出请求，熔断中返回 false 与剩余的熔断时间
+func (b *circuitBreaker) allow(cfg CircuitBreakerConfig) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if wait := time.Until(b.openedAt.Add(cfg.OpenTimeout)); wait > 0 {
+			return false, wait
+		}
+		b.setState(circuitHalfOpen)
+		b.probing = true
+		return true, 0
+	case circuitHalfOpen:
+		if b.probing {
+			return false, cfg.OpenTimeout
+		}
+		b.probing = true
+	}
+	return true, 0
+}
+
+// record 记录一次请求的结果
+func (b *circuitBreaker) record(cfg CircuitBreakerConfig, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.probing = false
+	}
+	if ok {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= cfg.FailureThreshold {
+		b.openedAt = time.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+// release 结束一个没有结果的半开探测，下一个请求重新探测
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// upstreamFailed 判断一次请求是否计为后端故障：连接失败或网关类 5xx
+func upstreamFailed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryBodyKey 保存可重试请求的请求体，由 messageMiddleware 为幂等的 JSON-RPC 请求设置
+const retryBodyKey contextKey = "retryBody"
+
+// 可以安全重试的 JSON-RPC 方法，它们不改变后端状态
+var idempotentMethods = map[string]bool{
+	"ping":                     true,
+	"tools/list":               true,
+	"prompts/list":             true,
+	"prompts/get":              true,
+	"resources/list":           true,
+	"resources/templates/list": true,
+	"resources/read":           true,
+}
+
+// withRetryBody 标记请求可以重试，重试时使用 body 重新发送
+func withRetryBody(ctx context.Context, body []byte) context.Context {
+	return context.WithValue(ctx, retryBodyKey, body)
+}
+
+// resilientTransport 在后端传输层之上实现熔断与重试
+// 建立 SSE 连接的 GET 与幂等的 JSON-RPC 请求在连接失败或网关类 5xx 时按退避重试，其余请求只尝试一次
+type resilientTransport struct {
+	next http.RoundTripper
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cfg := config().Resilience
+	origin := upstreamOrigin(req.URL)
+	breaker := breakerFor(origin)
+
+	body, retryable := req.Context().Value(retryBodyKey).([]byte)
+	if req.Method == http.MethodGet && (req.Body == nil || req.Body == http.NoBody) {
+		retryable = true
+	}
+	attempts := 1
+	if retryable {
+		attempts = cfg.Retry.Attempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if cfg.CircuitBreaker.FailureThreshold > 0 {
+			if ok, wait := breaker.allow(cfg.CircuitBreaker); !ok {
+				circuitRejectedTotal.WithLabelValues(origin).Inc()
+				return nil, &circuitOpenError{upstream: origin, retryAfter: wait}
+			}
+		}
+
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(req.Context())
+			if body != nil {
+				attemptReq.Body = io.NopCloser(bytes.NewReader(body))
+			}
+		}
+		resp, err := t.next.RoundTrip(attemptReq)
+		if err != nil && req.Context().Err() != nil {
+			// 客户端已放弃请求，结果不说明后端是否可用
+			breaker.release()
+			return resp, err
+		}
+		failed := upstreamFailed(resp, err)
+		if cfg.CircuitBreaker.FailureThreshold > 0 {
+			breaker.record(cfg.CircuitBreaker, !failed)
+		}
+		if !failed || attempt >= attempts {
+			return resp, err
+		}
+
+		// 丢弃失败的响应后等待重试
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		backoff := cfg.Retry.Backoff << (attempt - 1)
+		loggerFrom(req.Context()).Warn("retrying upstream request", "method", req.Method, "upstream", req.URL.String(), "attempt", attempt+1, "backoff", backoff.String(), "error", err)
+		upstreamRetriesTotal.WithLabelValues(origin).Inc()
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// circuitOpenError 是熔断时返回给调用方的错误，retryAfter 为剩余的熔断时间
+type circuitOpenError struct {
+	upstream   string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %v", e.upstream, errCircuitOpen)
+}
+
+func (e *circuitOpenError) Unwrap() error {
+	return errCircuitOpen
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	cfg := CircuitBreakerConfig{FailureThreshold: 3, OpenTimeout: 20 * time.Millisecond}
+	b := &circuitBreaker{upstream: "http://breaker.test", state: circuitClosed}
+	expect := func(step, state string) {
+		t.Helper()
+		if b.state != state {
+			t.Fatalf("%s: state %s, want %s", step, b.state, state)
+		}
+	}
+
+	// 成功会清零连续失败次数
+	b.record(cfg, false)
+	b.record(cfg, false)
+	b.record(cfg, true)
+	b.record(cfg, false)
+	b.record(cfg, false)
+	expect("two failures after success", circuitClosed)
+	b.record(cfg, false)
+	expect("threshold reached", circuitOpen)
+
+	if ok, wait := b.allow(cfg); ok || wait <= 0 || wait > cfg.OpenTimeout {
+		t.Fatalf("open: allow = %v, %s", ok, wait)
+	}
+	if state, until := circuitStatus(b.upstream); state != circuitClosed || !until.IsZero() {
+		t.Errorf("unregistered breaker reported as %s", state)
+	}
+
+	// 熔断结束后只放行一个探测请求，探测失败重新熔断
+	time.Sleep(cfg.OpenTimeout)
+	if ok, _ := b.allow(cfg); !ok {
+		t.Fatal("probe not allowed after open timeout")
+	}
+	expect("probing", circuitHalfOpen)
+	if ok, _ := b.allow(cfg); ok {
+		t.Fatal("second request allowed while probing")
+	}
+	b.record(cfg, false)
+	expect("probe failed", circuitOpen)
+
+	// 没有结果的探测释放后，下一个请求重新探测；探测成功恢复
+	time.Sleep(cfg.OpenTimeout)
+	b.allow(cfg)
+	b.release()
+	if ok, _ := b.allow(cfg); !ok {
+		t.Fatal("probe not allowed after release")
+	}
+	b.record(cfg, true)
+	expect("probe succeeded", circuitClosed)
+	if ok, _ := b.allow(cfg); !ok {
+		t.Fatal("closed breaker rejected request")
+	}
+}
+
+// roundTripFunc 以函数实现 http.RoundTripper
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestResilientTransport(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Resilience = ResilienceConfig{
+		CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 4, OpenTimeout: time.Minute},
+		Retry:          RetryConfig{Attempts: 3, Backoff: time.Millisecond},
+	}
+	useConfig(t, cfg)
+
+	calls := 0
+	transport := &resilientTransport{next: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+	})}
+	origin := "http://resilient.test"
+	t.Cleanup(func() {
+		breakersLock.Lock()
+		delete(breakers, origin)
+		breakersLock.Unlock()
+	})
+
+	// 建立 SSE 连接的 GET 按 attempts 重试
+	resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, origin+"/sse", nil))
+	if err != nil || resp.StatusCode != http.StatusBadGateway || calls != 3 {
+		t.Fatalf("GET: status %v, err %v, %d attempts; want 502 after 3 attempts", resp, err, calls)
+	}
+
+	// 非幂等的 POST 只尝试一次，失败计入熔断
+	calls = 0
+	post := httptest.NewRequest(http.MethodPost, origin+"/message", strings.NewReader(`{}`))
+	if _, err := transport.RoundTrip(post); err != nil || calls != 1 {
+		t.Fatalf("POST: err %v, %d attempts; want 1", err, calls)
+	}
+
+	// 连续 4 次失败后熔断，请求不再发出
+	calls = 0
+	_, err = transport.RoundTrip(httptest.NewRequest(http.MethodPost, origin+"/message", strings.NewReader(`{}`)))
+	var open *circuitOpenError
+	if !errors.As(err, &open) || !errors.Is(err, errCircuitOpen) || calls != 0 {
+		t.Fatalf("after threshold: err %v, %d attempts; want circuit open without attempts", err, calls)
+	}
+	if state, until := circuitStatus(origin + "/sse"); state != circuitOpen || until.IsZero() {
+		t.Errorf("circuitStatus = %s, %s", state, until)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_cache_requests_total",
+		Help: "Result cache lookups for cacheable tools/call requests, by result (hit or miss).",
+	}, []string{"route", "tool", "result"})
+
+	cacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mcp_gateway_cache_entries",
+		Help: "Tool results currently held in the result cache.",
+	})
+
+	cacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "mcp_gateway_cache_bytes",
+		Help: "Total size of the tool results held in the result cache.",
+	})
+)
+
+// 缓存命中时写入结果 _meta 的键，以及 message 端点响应中标记命中与否的头部
+const (
+	cacheMetaKey = "mcp-gateway/cache"
+	cacheHeader  = "X-MCP-Gateway-Cache"
+)
+
+// cacheEntry 是一个缓存的 tools/call 结果，disk 后端中每个结果保存为一个 JSON 文件
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Route   string          `json:"route"`
+	Tool    string          `json:"tool"`
+	User    string          `json:"user,omitempty"` // 只有 per_user 规则的结果记录调用者的 key_id
+	Stored  time.Time       `json:"stored"`
+	Expires time.Time       `json:"expires"`
+	Size    int64           `json:"size"`
+	Result  json.RawMessage `json:"result,omitempty"`
+}
+
+// resultCache 是按最近使用淘汰的工具结果缓存
+// memory 后端的结果保存在 entries 中；disk 后端只在内存中保留索引，结果按需从文件读取
+type resultCache struct {
+	dir string // disk 后端的目录，memory 后端为空
+
+	mu      sync.Mutex
+	lru     *list.List // 元素为 *cacheEntry，最近使用的在前
+	entries map[string]*list.Element
+	size    int64
+}
+
+// 当前使用的结果缓存
+var toolCache atomic.Pointer[resultCache]
+
+// newResultCache 创建结果缓存，disk 后端载入目录中未过期的结果
+func newResultCache(cfg CacheConfig) (*resultCache, error) {
+	c := &resultCache{lru: list.New(), entries: map[string]*list.Element{}}
+	if cfg.Backend != "disk" {
+		c.report()
+		return c, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	c.dir = cfg.Dir
+
+	files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var loaded []*cacheEntry
+	for _, file := range files {
+		var entry cacheEntry
+		data, err := os.ReadFile(file)
+		if err != nil || json.Unmarshal(data, &entry) != nil || entry.Key+".json" != filepath.Base(file) || !now.Before(entry.Expires) {
+			os.Remove(file)
+			continue
+		}
+		entry.Result = nil
+		loaded = append(loaded, &entry)
+	}
+	// 按保存时间恢复淘汰顺序
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Stored.Before(loaded[j].Stored) })
+	for _, entry := range loaded {
+		c.entries[entry.Key] = c.lru.PushFront(entry)
+		c.size += entry.Size
+	}
+	c.report()
+	return c, nil
+}
+
+// cacheKey 由路由、工具、参数与用户生成缓存键
+// 参数编码为 JSON 时对象的键按字典序排列，字段顺序不同的相同参数得到相同的键
+func cacheKey(route, tool string, args map[string]any, user string) string {
+	if args == nil {
+		args = map[string]any{}
+	}
+	data, _ := json.Marshal([]any{route, tool, args, user})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheUser 返回缓存键中的调用者，只有 per_user 规则区分调用者
+// 调用者以会话认证的 API Key 标识（见 apiKeyID），s.User 可由请求头伪造，不能用来隔离结果；
+// 没有 API Key 的会话无法区分调用者，per_user 规则对其不缓存，ok 为 false
+func cacheUser(rule *CacheRuleConfig, s *mcpSession) (user string, ok bool) {
+	if !rule.PerUser {
+		return "", true
+	}
+	if s.APIKey == nil {
+		return "", false
+	}
+	return apiKeyID(s.APIKey), true
+}
+
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+// get 返回未过期的缓存结果
+func (c *resultCache) get(key string, now time.Time) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !now.Before(entry.Expires) {
+		c.removeLocked(el)
+		c.report()
+		return nil, false
+	}
+	hit := *entry
+	if c.dir != "" {
+		var stored cacheEntry
+		data, err := os.ReadFile(c.path(key))
+		if err != nil || json.Unmarshal(data, &stored) != nil {
+			c.removeLocked(el)
+			c.report()
+			return nil, false
+		}
+		hit.Result = stored.Result
+	}
+	c.lru.MoveToFront(el)
+	return &hit, true
+}
+
+// set 保存结果，超过单个结果的大小上限时不缓存，总大小超出上限时淘汰最久未使用的结果
+func (c *resultCache) set(entry *cacheEntry, cfg CacheConfig) {
+	if entry.Size > int64(cfg.MaxEntryKB)<<10 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.Key]; ok {
+		c.removeLocked(el)
+	}
+	indexed := entry
+	if c.dir != "" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		file := c.path(entry.Key)
+		tmp := file + "." + instanceID + ".tmp"
+		if err := os.WriteFile(tmp, data, 0o600); err != nil {
+			logger.Warn("failed to write cache entry", "tool", entry.Tool, "error", err)
+			return
+		}
+		if err := os.Rename(tmp, file); err != nil {
+			logger.Warn("failed to write cache entry", "tool", entry.Tool, "error", err)
+			os.Remove(tmp)
+			return
+		}
+		copied := *entry
+		copied.Result = nil
+		indexed = &copied
+	}
+	c.entries[entry.Key] = c.lru.PushFront(indexed)
+	c.size += entry.Size
+
+	for maxBytes := int64(cfg.MaxSizeMB) << 20; c.size > maxBytes && c.lru.Len() > 0; {
+		c.removeLocked(c.lru.Back())
+	}
+	c.report()
+}
+
+// purge 删除匹配的结果，route、tool、user 为空时不按该字段过滤，返回删除的数量
+func (c *resultCache) purge(route, tool, user string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, el := range c.entries {
+		entry := el.Value.(*cacheEntry)
+		if (route == "" || entry.Route == route) && (tool == "" || entry.Tool == tool) && (user == "" || entry.User == user) {
+			c.removeLocked(el)
+			n++
+		}
+	}
+	c.report()
+	return n
+}
+
+// removeLocked 删除一个结果，调用方需持有 mu
+func (c *resultCache) removeLocked(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.entries, entry.Key)
+	c.size -= entry.Size
+	if c.dir != "" {
+		os.Remove(c.path(entry.Key))
+	}
+}
+
+// report 更新缓存大小指标，调用方需持有 mu
+func (c *resultCache) report() {
+	cacheEntries.Set(float64(len(c.entries)))
+	cacheBytes.Set(float64(c.size))
+}
+
+// cacheResult 保存后端返回的 tools/call 结果，工具没有开启缓存或调用失败时忽略
+func cacheResult(s *mcpSession, call *pendingCall, resp *jsonrpcMessage) {
+	if call.Method != "tools/call" || resp.isToolError() {
+		return
+	}
+	rule := config().policyFor(s.Prefix).cacheRule(call.Tool)
+	if rule == nil {
+		return
+	}
+	user, ok := cacheUser(rule, s)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	toolCache.Load().set(&cacheEntry{
+		Key:     cacheKey(s.Prefix, call.Tool, call.Args, user),
+		Route:   s.Prefix,
+		Tool:    call.Tool,
+		User:    user,
+		Stored:  now,
+		Expires: now.Add(rule.TTL),
+		Size:    int64(len(resp.Result)),
+		Result:  resp.Result,
+	}, config().Cache)
+}
+
+// resultWithMeta 返回写入了缓存信息的结果，客户端可从 _meta 中得知结果来自缓存及其新旧
+func (e *cacheEntry) resultWithMeta(now time.Time) json.RawMessage {
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(e.Result, &result); err != nil {
+		return e.Result
+	}
+	meta := map[string]any{}
+	if raw, ok := result["_meta"]; ok {
+		json.Unmarshal(raw, &meta)
+	}
+	meta[cacheMetaKey] = map[string]any{
+		"hit":         true,
+		"stored":      e.Stored,
+		"expires":     e.Expires,
+		"age_seconds": int(now.Sub(e.Stored).Seconds()),
+	}
+	result["_meta"], _ = json.Marshal(meta)
+	data, err := json.Marshal(result)
+	if err != nil {
+		return e.Result
+	}
+	return data
+}
+
+// cacheStatus 是 GET /admin/cache 的响应
+type cacheStatus struct {
+	Backend  string `json:"backend"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes"`
+}
+
+// AdminCache 管理结果缓存：GET 查询缓存大小，DELETE 清除缓存，route、tool、user 参数限定清除的范围
+func AdminCache(w http.ResponseWriter, r *http.Request) {
+	cache := toolCache.Load()
+	switch r.Method {
+	case http.MethodGet:
+		cfg := config().Cache
+		cache.mu.Lock()
+		status := cacheStatus{Backend: "memory", Entries: len(cache.entries), Bytes: cache.size, MaxBytes: int64(cfg.MaxSizeMB) << 20}
+		cache.mu.Unlock()
+		if cache.dir != "" {
+			status.Backend = "disk"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	case http.MethodDelete:
+		query := r.URL.Query()
+		route := query.Get("route")
+		if route != "" {
+			route = "/" + strings.Trim(route, "/")
+		}
+		n := cache.purge(route, query.Get("tool"), query.Get("user"))
+		logger.Info("result cache purged", "route", route, "tool", query.Get("tool"), "user", query.Get("user"), "entries", n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"purged": n})
+	default:
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+	}
+}
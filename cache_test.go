@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// testEntry 返回一个声明大小为 sizeKB 的缓存结果，实际内容很小
+func testEntry(key string, sizeKB int64, stored time.Time, ttl time.Duration) *cacheEntry {
+	return &cacheEntry{
+		Key:     key,
+		Route:   "/search",
+		Tool:    "web_search",
+		Stored:  stored,
+		Expires: stored.Add(ttl),
+		Size:    sizeKB << 10,
+		Result:  json.RawMessage(`{"content":[{"type":"text","text":"` + key + `"}]}`),
+	}
+}
+
+func TestResultCacheLRU(t *testing.T) {
+	cfg := CacheConfig{Backend: "memory", MaxSizeMB: 1, MaxEntryKB: 512}
+	c, err := newResultCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	c.set(testEntry("a", 400, now, time.Hour), cfg)
+	c.set(testEntry("b", 400, now, time.Hour), cfg)
+	// 访问 a 后 b 成为最久未使用的结果，超出总大小时被淘汰
+	if _, ok := c.get("a", now); !ok {
+		t.Fatal("a not cached")
+	}
+	c.set(testEntry("c", 400, now, time.Hour), cfg)
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(key, now); ok != want {
+			t.Errorf("get(%s) = %v, want %v", key, ok, want)
+		}
+	}
+	if c.size != 800<<10 {
+		t.Errorf("size = %d, want %d", c.size, 800<<10)
+	}
+
+	// 超过单个结果上限的结果不缓存
+	c.set(testEntry("big", 600, now, time.Hour), cfg)
+	if _, ok := c.get("big", now); ok {
+		t.Error("oversized entry was cached")
+	}
+
+	// 过期的结果在读取时删除
+	c.set(testEntry("short", 1, now, time.Second), cfg)
+	if _, ok := c.get("short", now.Add(time.Second)); ok {
+		t.Error("expired entry returned")
+	}
+	if _, ok := c.entries["short"]; ok {
+		t.Error("expired entry kept in index")
+	}
+
+	if n := c.purge("/search", "", ""); n != 2 || c.size != 0 {
+		t.Errorf("purge removed %d entries, size %d", n, c.size)
+	}
+}
+
+func TestResultCacheDiskReload(t *testing.T) {
+	cfg := CacheConfig{Backend: "disk", Dir: t.TempDir(), MaxSizeMB: 1, MaxEntryKB: 512}
+	c, err := newResultCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.set(testEntry("old", 400, now.Add(-2*time.Minute), time.Hour), cfg)
+	c.set(testEntry("new", 400, now.Add(-time.Minute), time.Hour), cfg)
+	c.set(testEntry("expired", 1, now.Add(-time.Hour), time.Minute), cfg)
+	// 不完整或无法解析的文件在载入时删除
+	broken := c.path("broken")
+	if err := os.WriteFile(broken, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newResultCache(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.entries) != 2 || reloaded.size != 800<<10 {
+		t.Fatalf("reloaded %d entries (%d bytes), want 2", len(reloaded.entries), reloaded.size)
+	}
+	if _, err := os.Stat(broken); !os.IsNotExist(err) {
+		t.Error("broken cache file kept")
+	}
+	if _, err := os.Stat(c.path("expired")); !os.IsNotExist(err) {
+		t.Error("expired cache file kept")
+	}
+	// 索引中不保存结果，读取时从文件载入
+	entry, ok := reloaded.get("new", now)
+	if !ok || string(entry.Result) != string(testEntry("new", 0, now, 0).Result) {
+		t.Fatalf("get(new) = %v, %v", entry, ok)
+	}
+
+	// 淘汰顺序按保存时间恢复：old 最久未使用，淘汰时文件一并删除
+	reloaded.set(testEntry("third", 400, now, time.Hour), cfg)
+	if _, ok := reloaded.get("old", now); ok {
+		t.Error("old entry not evicted")
+	}
+	if _, err := os.Stat(c.path("old")); !os.IsNotExist(err) {
+		t.Error("evicted cache file kept")
+	}
+}
+
+func TestCacheUser(t *testing.T) {
+	shared, perUser := &CacheRuleConfig{}, &CacheRuleConfig{PerUser: true}
+	alice := &APIKeyConfig{Key: "k-alice", User: "alice"}
+
+	if user, ok := cacheUser(shared, &mcpSession{User: "alice"}); !ok || user != "" {
+		t.Errorf("shared rule = %q, %v", user, ok)
+	}
+	// per_user 规则以 API Key 区分调用者，伪造的用户名不能读到他人的结果
+	user, ok := cacheUser(perUser, &mcpSession{User: "mallory", APIKey: alice})
+	if !ok || user != apiKeyID(alice) {
+		t.Errorf("per_user with key = %q, %v", user, ok)
+	}
+	if spoofed, _ := cacheUser(perUser, &mcpSession{User: "alice", APIKey: &APIKeyConfig{Key: "k-mallory"}}); spoofed == user {
+		t.Error("another key shares alice's cache entries")
+	}
+	if _, ok := cacheUser(perUser, &mcpSession{User: "alice"}); ok {
+		t.Error("per_user rule cached a session without an API key")
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	canarySessionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_canary_sessions_total",
+		Help: "New SSE sessions assigned to a version of a weighted route.",
+	}, []string{"route", "version"})
+
+	routeWeightGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_gateway_route_weight",
+		Help: "Current weight of each version of a weighted route.",
+	}, []string{"route", "version"})
+)
+
+// defaultVersion 表示不带版本注册的路由，与各版本一起参与按权重分配
+const defaultVersion = "default"
+
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// versionedPrefix 返回路由某个版本的前缀，如 /web_search@v2
+func versionedPrefix(prefix, version string) string {
+	if version == defaultVersion {
+		return prefix
+	}
+	return prefix + "@" + version
+}
+
+// routeName 返回前缀所属的路由名称，带版本的前缀如 /web_search@v2 返回 /web_search
+// 同一名称的各个版本共用名称的策略、配额与描述信息
+func routeName(prefix string) string {
+	name, _, _ := strings.Cut(prefix, "@")
+	return name
+}
+
+// canaryRouteKey 标记经 canaryRequest 分配到某个版本的请求，值为路由名称
+const canaryRouteKey contextKey = "canaryRoute"
+
+// canaryRouted 判断请求是否经 canaryRequest 分配，带版本的前缀只接受这样的请求
+func canaryRouted(r *http.Request) bool {
+	_, ok := r.Context().Value(canaryRouteKey).(string)
+	return ok
+}
+
+// registerVersion 在注册带版本的后端时登记权重，调用方需持有 routeMapLock
+// 指定 weight 时使用该权重；否则名称下的第一个后端得到全部流量，之后的新版本权重为 0，需要通过管理接口调整
+func registerVersion(prefix, version string, weight *int) {
+	weights := routeWeights[prefix]
+	if weights == nil {
+		weights = map[string]int{}
+		if _, ok := routeMap[prefix]; ok {
+			weights[defaultVersion] = 100
+		}
+		routeWeights[prefix] = weights
+	}
+	switch _, known := weights[version]; {
+	case weight != nil:
+		weights[version] = *weight
+	case len(weights) == 0:
+		weights[version] = 100
+	case !known:
+		weights[version] = 0
+	}
+	reportWeights(prefix, weights)
+}
+
+func reportWeights(prefix string, weights map[string]int) {
+	routeWeightGauge.DeletePartialMatch(prometheus.Labels{"route": prefix})
+	for version, weight := range weights {
+		routeWeightGauge.WithLabelValues(prefix, version).Set(float64(weight))
+	}
+}
+
+// canaryRequest 将访问具名路由的请求改写到其中一个版本：已有会话的请求发往会话所在的版本，
+// 新会话按权重选择版本。名称没有配置权重时原样返回
+func canaryRequest(r *http.Request) *http.Request {
+	name, rest := "/"+strings.TrimPrefix(r.URL.Path, "/"), ""
+	if i := strings.Index(name[1:], "/"); i >= 0 {
+		name, rest = name[:i+1], name[i+1:]
+	}
+
+	routeMapLock.RLock()
+	weights := map[string]int{}
+	for version, weight := range routeWeights[name] {
+		// 已删除的版本不再分配
+		if _, ok := routeMap[versionedPrefix(name, version)]; ok {
+			weights[version] = weight
+		}
+	}
+	routeMapLock.RUnlock()
+	if len(weights) == 0 {
+		return r
+	}
+
+	version, ok := sessionVersion(r, name, weights)
+	if !ok {
+		if version, ok = pickVersion(name, weights); !ok {
+			return r
+		}
+		if r.Method == http.MethodGet {
+			canarySessionsTotal.WithLabelValues(name, version).Inc()
+		}
+	}
+	if version == defaultVersion {
+		return r
+	}
+
+	r = r.WithContext(context.WithValue(r.Context(), canaryRouteKey, name))
+	u := *r.URL
+	u.Path, u.RawPath = versionedPrefix(name, version)+rest, ""
+	r.URL = &u
+	return r
+}
+
+// sessionVersion 返回请求所属会话所在的版本，会话 id 取自 sessionId 参数或重连时的 Last-Event-ID / Mcp-Session-Id
+func sessionVersion(r *http.Request, name string, weights map[string]int) (string, bool) {
+	id := r.URL.Query().Get("sessionId")
+	if id == "" {
+		id, _, _ = reattachTarget(r)
+	}
+	if id == "" {
+		return "", false
+	}
+	for version := range weights {
+		if lookupSession(versionedPrefix(name, version), id) != nil {
+			return version, true
+		}
+	}
+	// 其他实例上的会话从持久化记录中找到所在的版本
+	rec, _ := sessionStore.Load().load(id)
+	switch {
+	case rec == nil:
+		return "", false
+	case rec.Route == name:
+		return defaultVersion, true
+	case strings.HasPrefix(rec.Route, name+"@"):
+		return strings.TrimPrefix(rec.Route, name+"@"), true
+	}
+	return "", false
+}
+
+// pickVersion 按权重随机选择一个版本，排空中的版本不参与；没有可选的版本时返回 false
+func pickVersion(name string, weights map[string]int) (string, bool) {
+	versions := slices.Sorted(maps.Keys(weights))
+	total := 0
+	for _, version := range versions {
+		if isRouteDraining(versionedPrefix(name, version)) {
+			weights[version] = 0
+		}
+		total += weights[version]
+	}
+	if total <= 0 {
+		return "", false
+	}
+	n := rand.IntN(total)
+	for _, version := range versions {
+		if n < weights[version] {
+			return version, true
+		}
+		n -= weights[version]
+	}
+	return "", false
+}
+
+// routeWeightsStatus 是 /admin/routes/weights 的响应
+type routeWeightsStatus struct {
+	Route    string            `json:"route"`
+	Weights  map[string]int    `json:"weights"`
+	Versions map[string]string `json:"versions"` // 已注册的版本及其后端地址
+}
+
+// weightsStatus 返回具名路由的权重与版本，调用方需持有 routeMapLock
+func weightsStatus(name string) routeWeightsStatus {
+	status := routeWeightsStatus{Route: name, Weights: maps.Clone(routeWeights[name]), Versions: map[string]string{}}
+	if status.Weights == nil {
+		status.Weights = map[string]int{}
+	}
+	for prefix, target := range routeMap {
+		if prefix == name {
+			status.Versions[defaultVersion] = target
+		} else if version, ok := strings.CutPrefix(prefix, name+"@"); ok {
+			status.Versions[version] = target
+		}
+	}
+	return status
+}
+
+// AdminRouteWeights 查询或调整具名路由各版本的权重：GET 返回当前权重，PUT 以 {"weights": {"v1": 90, "v2": 10}} 整体替换，
+// 未列出的版本权重为 0。不带 route 参数的 GET 返回所有配置了权重的路由
+func AdminRouteWeights(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	if route != "" {
+		route = "/" + strings.Trim(route, "/")
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		routeMapLock.RLock()
+		var statuses []routeWeightsStatus
+		for _, name := range slices.Sorted(maps.Keys(routeWeights)) {
+			if route == "" || name == route {
+				statuses = append(statuses, weightsStatus(name))
+			}
+		}
+		routeMapLock.RUnlock()
+		if route != "" && len(statuses) == 0 {
+			writeError(w, r, kindNotFound, "route has no versions", map[string]any{"route": route})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if route != "" {
+			json.NewEncoder(w).Encode(statuses[0])
+		} else {
+			json.NewEncoder(w).Encode(map[string]any{"routes": statuses})
+		}
+	case http.MethodPut, http.MethodPost:
+		if route == "" {
+			writeError(w, r, kindBadRequest, "missing route parameter", nil)
+			return
+		}
+		var req struct {
+			Weights map[string]int `json:"weights"`
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			writeError(w, r, kindBadRequest, "invalid request body: "+err.Error(), nil)
+			return
+		}
+
+		routeMapLock.Lock()
+		status := weightsStatus(route)
+		weights := map[string]int{}
+		for version := range status.Versions {
+			weights[version] = 0
+		}
+		total := 0
+		for version, weight := range req.Weights {
+			if _, ok := status.Versions[version]; !ok {
+				routeMapLock.Unlock()
+				writeError(w, r, kindNotFound, fmt.Sprintf("version %q is not registered", version), map[string]any{"route": route, "version": version})
+				return
+			}
+			if weight < 0 {
+				routeMapLock.Unlock()
+				writeError(w, r, kindBadRequest, fmt.Sprintf("weight of version %q must not be negative", version), nil)
+				return
+			}
+			weights[version] = weight
+			total += weight
+		}
+		if total <= 0 {
+			routeMapLock.Unlock()
+			writeError(w, r, kindBadRequest, "at least one version must have a positive weight", nil)
+			return
+		}
+		routeWeights[route] = weights
+		reportWeights(route, weights)
+		status = weightsStatus(route)
+		routeMapLock.Unlock()
+
+		logger.Info("route weights updated", "route", route, "weights", weights)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	default:
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+	}
+}
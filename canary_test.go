@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func register(t *testing.T, body string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Register(rec, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register %s: status %d: %s", body, rec.Code, rec.Body)
+	}
+}
+
+func TestPickVersion(t *testing.T) {
+	counts := map[string]int{}
+	for range 2000 {
+		version, ok := pickVersion("/search", map[string]int{"default": 75, "v2": 25, "v3": 0})
+		if !ok {
+			t.Fatal("no version picked")
+		}
+		counts[version]++
+	}
+	if counts["v3"] != 0 {
+		t.Errorf("zero weight version picked %d times", counts["v3"])
+	}
+	if counts["default"] < 1300 || counts["default"] > 1700 || counts["v2"] < 300 || counts["v2"] > 700 {
+		t.Errorf("picks %v do not follow weights 75/25", counts)
+	}
+
+	// 排空中的版本不参与分配，没有可选版本时返回 false
+	setRouteDraining("/search@v2", true)
+	t.Cleanup(func() { setRouteDraining("/search@v2", false) })
+	for range 100 {
+		if version, ok := pickVersion("/search", map[string]int{"default": 1, "v2": 99}); !ok || version != "default" {
+			t.Fatalf("picked %q, %v while v2 is draining", version, ok)
+		}
+	}
+	if version, ok := pickVersion("/search", map[string]int{"v2": 100}); ok {
+		t.Errorf("picked draining version %q", version)
+	}
+	if _, ok := pickVersion("/search", map[string]int{"default": 0}); ok {
+		t.Error("picked a version with all weights zero")
+	}
+}
+
+func TestAdminRouteWeights(t *testing.T) {
+	resetRoutes(t)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:1/sse"}`)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:2/sse","version":"v2"}`)
+
+	put := func(route, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		AdminRouteWeights(rec, httptest.NewRequest(http.MethodPut, "/admin/routes/weights?route="+route, strings.NewReader(body)))
+		return rec
+	}
+	tests := []struct {
+		name   string
+		route  string
+		body   string
+		status int
+	}{
+		{"missing route", "", `{"weights":{"v2":1}}`, http.StatusBadRequest},
+		{"invalid body", "search", `{"weights":`, http.StatusBadRequest},
+		{"unknown version", "search", `{"weights":{"v9":10}}`, http.StatusNotFound},
+		{"negative weight", "search", `{"weights":{"v2":-1,"default":10}}`, http.StatusBadRequest},
+		{"all zero", "search", `{"weights":{"v2":0}}`, http.StatusBadRequest},
+		{"valid", "/search/", `{"weights":{"v2":10}}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := put(tt.route, tt.body); rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+	}
+
+	// 未列出的版本权重为 0
+	rec := httptest.NewRecorder()
+	AdminRouteWeights(rec, httptest.NewRequest(http.MethodGet, "/admin/routes/weights?route=search", nil))
+	var status routeWeightsStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Weights["default"] != 0 || status.Weights["v2"] != 10 || status.Versions["v2"] != "http://127.0.0.1:2/sse" {
+		t.Errorf("status = %+v", status)
+	}
+
+	rec = httptest.NewRecorder()
+	AdminRouteWeights(rec, httptest.NewRequest(http.MethodGet, "/admin/routes/weights?route=files", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("route without versions: status %d", rec.Code)
+	}
+}
+
+func TestRegisterVersionWeights(t *testing.T) {
+	resetRoutes(t)
+	cfg := defaultConfig()
+	cfg.Admin.Token = "admin-secret"
+	useConfig(t, cfg)
+	registerWith := func(token, body string) int {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		Register(rec, r)
+		return rec.Code
+	}
+
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:1/sse","version":"v1"}`)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:2/sse","version":"v2"}`)
+	if code := registerWith("admin-secret", `{"server_name":"search","server_url":"http://127.0.0.1:3/sse","version":"v3","weight":5}`); code != http.StatusOK {
+		t.Fatalf("register with admin token: status %d", code)
+	}
+	// 未认证的注册不能给自己分配流量
+	for _, token := range []string{"", "wrong"} {
+		if code := registerWith(token, `{"server_name":"search","server_url":"http://127.0.0.1:4/sse","version":"v4","weight":100}`); code != http.StatusOK {
+			t.Fatalf("register without admin token: status %d", code)
+		}
+	}
+
+	routeMapLock.RLock()
+	weights := routeWeights["/search"]
+	routeMapLock.RUnlock()
+	// 第一个版本得到全部流量，之后的版本默认权重为 0
+	if weights["v1"] != 100 || weights["v2"] != 0 || weights["v3"] != 5 || weights["v4"] != 0 {
+		t.Errorf("weights = %v", weights)
+	}
+
+	for _, body := range []string{
+		`{"server_name":"search","server_url":"http://127.0.0.1:4/sse","version":"default"}`,
+		`{"server_name":"search","server_url":"http://127.0.0.1:4/sse","version":"bad/version"}`,
+		`{"server_name":"search","server_url":"http://127.0.0.1:4/sse","weight":5}`,
+		`{"server_name":"search@v9","server_url":"http://127.0.0.1:4/sse"}`,
+	} {
+		if code := registerWith("admin-secret", body); code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, code)
+		}
+	}
+}
+
+func TestVersionUsesRoutePolicy(t *testing.T) {
+	resetRoutes(t)
+	cfg := authConfig(t)
+	required := true
+	cfg.Routes = []RouteConfig{{Name: "search", Prefix: "/search", Transport: "sse", Upstream: "http://127.0.0.1:1/sse",
+		Policies: PolicyConfig{RequireAuth: &required, DenyTools: []string{"delete"}}}}
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:2/sse","version":"v2"}`)
+
+	// 版本沿用名称的策略，不能借此绕过认证与工具限制
+	policy := cfg.policyFor("/search@v2")
+	if policy.RequireAuth == nil || !*policy.RequireAuth || policy.toolAllowed("delete") {
+		t.Errorf("policy of /search@v2 = %+v", policy)
+	}
+	if md := routeMetadataFor("/search@v2"); md.Auth == nil || !md.Auth.Required {
+		t.Errorf("metadata of /search@v2 = %+v", md)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/message", nil)
+	r = r.WithContext(context.WithValue(r.Context(), apiKeyKey, &cfg.Auth.APIKeys[0]))
+	if k := quotaUsageKey(r, "/search@v2", "web_search"); k.Route != "/search" || k.Tenant != "acme" {
+		t.Errorf("usage key = %+v", k)
+	}
+	limit := RateLimitConfig{Name: "calls", Limit: 1, Period: time.Second, Burst: 1}
+	if limit.bucketKey("/search@v2", "web_search", r) != limit.bucketKey("/search", "web_search", r) {
+		t.Error("versions do not share rate limit buckets")
+	}
+
+	rec := httptest.NewRecorder()
+	authMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/sse", nil).WithContext(context.WithValue(context.Background(), prefixKey, "/search@v2")))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request to /search@v2: status %d", rec.Code)
+	}
+}
+
+func TestServeRouteVersions(t *testing.T) {
+	resetRoutes(t)
+	sseTestConfig(t)
+	stable, canary := newFakeUpstream(t), newFakeUpstream(t)
+	register(t, `{"server_name":"search","server_url":"`+stable.URL+`/sse"}`)
+	register(t, `{"server_name":"search","server_url":"`+canary.URL+`/sse","version":"v2"}`)
+	routeMapLock.Lock()
+	routeWeights["/search"] = map[string]int{"default": 0, "v2": 100}
+	routeMapLock.Unlock()
+	gw := httptest.NewServer(http.HandlerFunc(serveRoute))
+	t.Cleanup(func() {
+		for _, s := range sessionsSnapshot("") {
+			s.close()
+		}
+		gw.Close()
+	})
+
+	// 新会话按权重进入 v2，客户端看到的仍是名称的地址
+	c := dialSSE(t, gw.URL+"/search/sse", nil)
+	endpoint := c.waitEndpoint()
+	if !strings.Contains(endpoint, "/search/message?sessionId=") {
+		t.Fatalf("endpoint %q exposes the version", endpoint)
+	}
+	if code, body := c.post(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, nil); code != http.StatusAccepted {
+		t.Fatalf("POST: %d %s", code, body)
+	}
+	c.nextMessage(5 * time.Second)
+	if got := canary.methods(); len(got) != 1 || len(stable.methods()) != 0 {
+		t.Errorf("canary received %v, stable %v", got, stable.methods())
+	}
+
+	// 直接访问带版本的前缀被拒绝，已有会话的 message 端点也不例外
+	for _, target := range []string{"/search@v2/sse", "/search@v2/message?sessionId=" + endpointSession(t, endpoint)} {
+		resp, err := http.Post(gw.URL+target, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", target, resp.StatusCode)
+		}
+	}
+}
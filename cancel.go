@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var toolCallCancellationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mcp_gateway_tool_call_cancellations_total",
+	Help: "tools/call requests abandoned before a result arrived, by reason (timeout or client).",
+}, []string{"route", "tool", "reason"})
+
+// errCallAbandoned 是网关放弃等待的请求的取消原因，客户端已经得到结果，转发中的请求随之结束
+var errCallAbandoned = errors.New("call abandoned by gateway")
+
+// 取消记录的保留时间，超过后不再丢弃后端迟到的响应
+const cancelledRetention = 10 * time.Minute
+
+// cancelledParams 是 notifications/cancelled 的参数
+type cancelledParams struct {
+	RequestID json.RawMessage `json:"requestId"`
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// markCancelledLocked 记录不再等待结果的请求 id，并清理过期的记录，调用方需持有 mu
+func (s *mcpSession) markCancelledLocked(key string) {
+	now := time.Now()
+	for k, at := range s.cancelled {
+		if now.Sub(at) > cancelledRetention {
+			delete(s.cancelled, k)
+		}
+	}
+	s.cancelled[key] = now
+}
+
+// takeCancelled 判断响应是否属于已取消的请求，是则移除记录
+func (s *mcpSession) takeCancelled(id json.RawMessage) bool {
+	key := idKey(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cancelled[key]; !ok {
+		return false
+	}
+	delete(s.cancelled, key)
+	return true
+}
+
+// cancelUpstream 向后端发送 notifications/cancelled，通知其停止处理该请求
+func (s *mcpSession) cancelUpstream(id json.RawMessage, reason string) {
+	s.mu.Lock()
+	endpoint := s.upstreamEndpoint
+	s.mu.Unlock()
+	if endpoint == "" {
+		return
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"method":  "notifications/cancelled",
+		"params":  cancelledParams{RequestID: id, Reason: reason},
+	})
+	if err := postUpstream(context.Background(), endpoint, string(body)); err != nil {
+		s.log.Warn("failed to cancel upstream request", "id", string(id), "error", err)
+	}
+}
+
+// expireCall 在工具调用超时后向客户端返回 JSON-RPC 错误并通知后端取消，后端随后返回的结果会被丢弃
+func (s *mcpSession) expireCall(key string, call *pendingCall, timeout time.Duration) {
+	s.mu.Lock()
+	if s.pending[key] != call {
+		// 结果已经到达
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, key)
+	s.markCancelledLocked(key)
+	s.mu.Unlock()
+
+	message := fmt.Sprintf("tool %q timed out after %s", call.Tool, timeout)
+	resp := &jsonrpcMessage{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      call.ID,
+		Error: &jsonrpcError{
+			Code:    kindTimeout.Code,
+			Message: message,
+			Data:    map[string]any{"type": kindTimeout.Type, "tool": call.Tool, "timeout": timeout.String()},
+		},
+	}
+	s.send(resp)
+	call.abort(errCallAbandoned)
+	s.log.Warn("tool call timed out", "tool", call.Tool, "id", string(call.ID), "timeout", timeout.String())
+	toolCallCancellationsTotal.WithLabelValues(s.Prefix, call.Tool, "timeout").Inc()
+	endCallSpan(call.Span, resp)
+	auditor.Load().record(s, call, resp, 0)
+	observeToolCall(s, call, resp)
+
+	s.cancelUpstream(call.ID, message)
+}
+
+// clientCancelled 处理客户端发出的 notifications/cancelled：不再等待该请求的结果，通知本身照常转发到后端
+func (s *mcpSession) clientCancelled(params json.RawMessage) {
+	var p cancelledParams
+	if err := json.Unmarshal(params, &p); err != nil || len(p.RequestID) == 0 {
+		return
+	}
+	key := idKey(p.RequestID)
+
+	s.mu.Lock()
+	call, ok := s.pending[key]
+	if ok {
+		delete(s.pending, key)
+		if call.timer != nil {
+			call.timer.Stop()
+		}
+		s.markCancelledLocked(key)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	call.abort(errCallAbandoned)
+
+	s.log.Info("request cancelled by client", "method", call.Method, "tool", call.Tool, "id", string(call.ID), "reason", p.Reason)
+	if call.Method == "tools/call" {
+		toolCallCancellationsTotal.WithLabelValues(s.Prefix, call.Tool, "client").Inc()
+	}
+	if call.Span != nil {
+		call.Span.SetStatus(codes.Error, "cancelled by client")
+		call.Span.End()
+	}
+}
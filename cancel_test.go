@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+// blockingUpstream 的 tools/call 在 release 关闭前不返回结果
+func blockingUpstream(t *testing.T) (*fakeUpstream, chan struct{}) {
+	upstream := newFakeUpstream(t)
+	release := make(chan struct{})
+	upstream.handle = func(msg *jsonrpcMessage) any {
+		if msg.Method == "tools/call" {
+			<-release
+		}
+		return map[string]any{"method": msg.Method}
+	}
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	return upstream, release
+}
+
+// waitReceived 等待后端收到指定方法的消息
+func waitReceived(t *testing.T, upstream *fakeUpstream, method string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if slices.Contains(upstream.methods(), method) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("upstream did not receive %s, got %v", method, upstream.methods())
+}
+
+// expectNextResponse 放行后端迟到的结果并确认其被丢弃：下一个消息是随后请求的响应
+func expectNextResponse(t *testing.T, c *sseClient, release chan struct{}, id int) {
+	t.Helper()
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	if code, body := c.post(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/list"}`, id), nil); code != http.StatusAccepted {
+		t.Fatalf("tools/list: %d %s", code, body)
+	}
+	if msg := c.nextMessage(5 * time.Second); string(msg.ID) != fmt.Sprint(id) {
+		t.Errorf("next message = %+v, want response to %d", msg, id)
+	}
+}
+
+func TestToolCallTimeout(t *testing.T) {
+	resetRoutes(t)
+	cfg := sseTestConfig(t)
+	cfg.Policies.ToolTimeouts = map[string]time.Duration{"slow": 100 * time.Millisecond}
+	upstream, release := blockingUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+	timeouts := toolCallCancellationsTotal.WithLabelValues("/search", "slow", "timeout")
+	before := metricValue(t, timeouts)
+
+	c := dialSSE(t, gw.URL+"/search/sse", nil)
+	c.waitEndpoint()
+	if code, body := c.post(`{"jsonrpc":"2.0","id":9,"method":"tools/call","params":{"name":"slow","arguments":{}}}`, nil); code != http.StatusAccepted {
+		t.Fatalf("tools/call: %d %s", code, body)
+	}
+
+	// 超时后客户端得到 JSON-RPC 错误，后端收到取消通知
+	msg := c.nextMessage(5 * time.Second)
+	if string(msg.ID) != "9" || msg.Error == nil || msg.Error.Code != kindTimeout.Code {
+		t.Fatalf("response = %+v", msg)
+	}
+	if data, _ := msg.Error.Data.(map[string]any); data["type"] != "timeout" || data["tool"] != "slow" || data["timeout"] != "100ms" {
+		t.Errorf("error data = %v", msg.Error.Data)
+	}
+	waitReceived(t, upstream, "notifications/cancelled")
+	if got := metricValue(t, timeouts) - before; got != 1 {
+		t.Errorf("timeout cancellations = %v, want 1", got)
+	}
+
+	expectNextResponse(t, c, release, 10)
+}
+
+func TestClientCancelledForwarded(t *testing.T) {
+	resetRoutes(t)
+	sseTestConfig(t)
+	upstream, release := blockingUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+	cancelled := toolCallCancellationsTotal.WithLabelValues("/search", "web_search", "client")
+	before := metricValue(t, cancelled)
+
+	c := dialSSE(t, gw.URL+"/search/sse", nil)
+	c.waitEndpoint()
+	if code, body := c.post(`{"jsonrpc":"2.0","id":11,"method":"tools/call","params":{"name":"web_search","arguments":{}}}`, nil); code != http.StatusAccepted {
+		t.Fatalf("tools/call: %d %s", code, body)
+	}
+	waitReceived(t, upstream, "tools/call")
+
+	// 客户端的取消通知转发到后端，网关不再等待该请求的结果
+	if code, body := c.post(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":11,"reason":"user"}}`, nil); code != http.StatusAccepted {
+		t.Fatalf("notifications/cancelled: %d %s", code, body)
+	}
+	waitReceived(t, upstream, "notifications/cancelled")
+	upstream.mu.Lock()
+	params := string(upstream.received[len(upstream.received)-1].Params)
+	upstream.mu.Unlock()
+	if params != `{"requestId":11,"reason":"user"}` {
+		t.Errorf("forwarded params = %s", params)
+	}
+	if got := metricValue(t, cancelled) - before; got != 1 {
+		t.Errorf("client cancellations = %v, want 1", got)
+	}
+
+	expectNextResponse(t, c, release, 12)
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// 磁带是一个会话的录制文件（JSON Lines）：第一行是 cassetteHeader，之后每行是一条 cassetteMessage
+type cassetteHeader struct {
+	Route   string    `json:"route"`
+	Session string    `json:"session"`
+	Started time.Time `json:"started"`
+}
+
+type cassetteMessage struct {
+	OffsetMs int64           `json:"offset_ms"` // 相对会话开始的毫秒数
+	From     string          `json:"from"`      // client 或 server
+	Message  json.RawMessage `json:"message"`
+}
+
+// cassetteRecorder 将会话中客户端与后端之间的 JSON-RPC 消息写入磁带，文件在第一条消息时创建
+type cassetteRecorder struct {
+	dir     string
+	route   string
+	session string
+	started time.Time
+
+	mu     sync.Mutex
+	file   *os.File
+	failed bool
+}
+
+func newCassetteRecorder(dir, route, session string) *cassetteRecorder {
+	return &cassetteRecorder{dir: dir, route: route, session: session, started: time.Now()}
+}
+
+// record 写入一条消息，写入失败后不再录制该会话
+func (c *cassetteRecorder) record(from string, data []byte) {
+	if c == nil {
+		return
+	}
+	if from == "client" {
+		data = redactToolCall(data)
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, data); err != nil {
+		return
+	}
+	line, _ := json.Marshal(cassetteMessage{
+		OffsetMs: time.Since(c.started).Milliseconds(),
+		From:     from,
+		Message:  compact.Bytes(),
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed {
+		return
+	}
+	if c.file == nil {
+		if err := c.open(); err != nil {
+			c.failed = true
+			logger.Error("failed to create cassette", "route", c.route, "session", c.session, "error", err)
+			return
+		}
+	}
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		c.failed = true
+		logger.Error("failed to write cassette", "path", c.file.Name(), "error", err)
+	}
+}
+
+// open 创建磁带文件并写入会话信息，调用方需持有 mu
+func (c *cassetteRecorder) open() error {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s-%s.jsonl", strings.ReplaceAll(strings.Trim(c.route, "/"), "/", "_"), c.started.Format("20060102T150405"), c.session[:8])
+	f, err := os.OpenFile(filepath.Join(c.dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	header, _ := json.Marshal(cassetteHeader{Route: c.route, Session: c.session, Started: c.started})
+	if _, err := f.Write(append(header, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	c.file = f
+	logger.Info("recording session", "route", c.route, "session", c.session, "path", f.Name())
+	return nil
+}
+
+// Close 关闭磁带文件
+func (c *cassetteRecorder) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// redactToolCall 按 audit.redact 的规则脱敏 tools/call 请求的参数（不要求开启审计日志），其他消息原样返回
+func redactToolCall(data []byte) []byte {
+	msg, ok := parseJSONRPC(data)
+	if !ok || msg.toolCall() == nil {
+		return data
+	}
+	params := redactedParams(msg)
+	if bytes.Equal(params, msg.Params) {
+		return data
+	}
+	msg.Params = params
+	redacted, err := json.Marshal(msg)
+	if err != nil {
+		return data
+	}
+	return redacted
+}
+
+// redactedParams 返回按 audit.redact 脱敏后的 tools/call 参数，录制与回放匹配使用同样的结果
+func redactedParams(msg *jsonrpcMessage) json.RawMessage {
+	call := msg.toolCall()
+	if call == nil {
+		return msg.Params
+	}
+	rules, _ := parseRedactRules(config().Audit.Redact)
+	var params map[string]any
+	if len(rules) == 0 || json.Unmarshal(msg.Params, &params) != nil {
+		return msg.Params
+	}
+	redacted := (&auditLogger{rules: rules}).redact(call.Name, call.Arguments)
+	if redacted == nil {
+		return msg.Params
+	}
+	params["arguments"] = redacted
+	data, err := json.Marshal(params)
+	if err != nil {
+		return msg.Params
+	}
+	return data
+}
+
+// cassetteExchange 是磁带中一次请求与后端的响应
+type cassetteExchange struct {
+	method   string
+	params   string          // 见 replayKey
+	response json.RawMessage // 后端返回的完整 JSON-RPC 响应
+	latency  time.Duration
+}
+
+// replayKey 返回匹配请求时使用的参数：去掉 _meta 后规范化的 JSON
+// initialize 的参数包含客户端信息，只按方法匹配
+func replayKey(method string, params json.RawMessage) string {
+	if method == "initialize" || len(params) == 0 {
+		return ""
+	}
+	var p map[string]any
+	if err := json.Unmarshal(params, &p); err != nil {
+		return string(params)
+	}
+	delete(p, "_meta")
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// loadCassettes 读取磁带文件，path 为目录时读取其中所有 .jsonl 文件，返回按请求顺序排列的请求与响应
+func loadCassettes(path string) ([]cassetteExchange, error) {
+	files := []string{path}
+	if info, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.jsonl")); err != nil {
+			return nil, err
+		}
+	}
+
+	var exchanges []cassetteExchange
+	for _, file := range files {
+		loaded, err := loadCassette(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		exchanges = append(exchanges, loaded...)
+	}
+	if len(exchanges) == 0 {
+		return nil, errors.New("cassette contains no recorded responses")
+	}
+	return exchanges, nil
+}
+
+func loadCassette(file string) ([]cassetteExchange, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	type request struct {
+		index  int
+		offset int64
+	}
+	var exchanges []cassetteExchange
+	requests := map[string]request{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxMessageBodySize+4096)
+	for line := 1; scanner.Scan(); line++ {
+		if line == 1 {
+			continue // 会话信息
+		}
+		var rec cassetteMessage
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		msg, ok := parseJSONRPC(rec.Message)
+		if !ok {
+			continue
+		}
+		switch {
+		case rec.From == "client" && msg.isRequest():
+			requests[idKey(msg.ID)] = request{index: len(exchanges), offset: rec.OffsetMs}
+			exchanges = append(exchanges, cassetteExchange{method: msg.Method, params: replayKey(msg.Method, msg.Params)})
+		case rec.From == "server" && msg.isResponse():
+			req, ok := requests[idKey(msg.ID)]
+			if !ok {
+				continue
+			}
+			delete(requests, idKey(msg.ID))
+			exchanges[req.index].response = rec.Message
+			exchanges[req.index].latency = time.Duration(rec.OffsetMs-req.offset) * time.Millisecond
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// 去掉没有等到响应的请求
+	answered := exchanges[:0]
+	for _, ex := range exchanges {
+		if ex.response != nil {
+			answered = append(answered, ex)
+		}
+	}
+	return answered, nil
+}
+
+// startReplayServer 启动按磁带应答的本地 MCP 服务
+func startReplayServer(name string, cfg ReplayConfig) (*localMCPServer, error) {
+	exchanges, err := loadCassettes(cfg.Cassette)
+	if err != nil {
+		return nil, fmt.Errorf("load cassette: %w", err)
+	}
+	logger.Info("replay server loaded cassette", "server", name, "cassette", cfg.Cassette, "exchanges", len(exchanges))
+	return startLocalMCPServer(name, func() localHandler {
+		return replayHandler(name, exchanges, cfg.Realtime)
+	})
+}
+
+// replayHandler 为一个会话按磁带应答请求：方法与参数相同的请求依次使用录制的响应，
+// 全部用过后重复最后一个，因此同样的调用序列总是得到同样的结果
+func replayHandler(name string, exchanges []cassetteExchange, realtime bool) localHandler {
+	var mu sync.Mutex
+	used := make([]bool, len(exchanges))
+
+	return func(msg *jsonrpcMessage) *jsonrpcMessage {
+		// 磁带中的参数已按 audit.redact 脱敏，匹配前对请求做同样的处理
+		key := replayKey(msg.Method, redactedParams(msg))
+		mu.Lock()
+		match := -1
+		for i, ex := range exchanges {
+			if ex.method != msg.Method || ex.params != key {
+				continue
+			}
+			match = i
+			if !used[i] {
+				break
+			}
+		}
+		if match >= 0 {
+			used[match] = true
+		}
+		mu.Unlock()
+
+		if match < 0 {
+			if msg.Method == "ping" {
+				return &jsonrpcMessage{JSONRPC: mcp.JSONRPC_VERSION, ID: msg.ID, Result: json.RawMessage("{}")}
+			}
+			logger.Warn("no recorded response", "server", name, "method", msg.Method, "params", key)
+			return &jsonrpcMessage{
+				JSONRPC: mcp.JSONRPC_VERSION,
+				ID:      msg.ID,
+				Error: &jsonrpcError{
+					Code:    kindNotFound.Code,
+					Message: fmt.Sprintf("no recorded response for %s in cassette", msg.Method),
+					Data:    map[string]any{"type": kindNotFound.Type, "method": msg.Method},
+				},
+			}
+		}
+
+		ex := exchanges[match]
+		if realtime {
+			time.Sleep(ex.latency)
+		}
+		var resp jsonrpcMessage
+		if err := json.Unmarshal(ex.response, &resp); err != nil {
+			return nil
+		}
+		resp.ID = msg.ID
+		return &resp
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCassetteRecordReplay(t *testing.T) {
+	resetRoutes(t)
+	cfg := sseTestConfig(t)
+	dir := t.TempDir()
+	cfg.Routes = []RouteConfig{{Name: "search", Prefix: "/search", Transport: "sse", Record: dir}}
+	upstream := newFakeUpstream(t)
+	upstream.handle = func(msg *jsonrpcMessage) any {
+		if params := msg.toolCall(); params != nil {
+			return map[string]any{"content": []any{map[string]any{"type": "text", "text": "results for " + params.Arguments["q"].(string)}}}
+		}
+		return map[string]any{"method": msg.Method}
+	}
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	// 录制：经过网关的请求与后端响应写入磁带
+	c, _ := openSession(t, gw.URL, nil)
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"web_search","arguments":{"q":"go"},"_meta":{"progressToken":"p"}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"web_search","arguments":{"q":"rust"}}}`,
+	} {
+		if code, resp := c.post(body, nil); code != http.StatusAccepted {
+			t.Fatalf("POST: %d %s", code, resp)
+		}
+		c.nextMessage(5 * time.Second)
+	}
+	var exchanges []cassetteExchange
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exchanges) < 3 && time.Now().Before(deadline) {
+		exchanges, _ = loadCassettes(dir)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(exchanges) != 3 || exchanges[0].method != "initialize" || exchanges[1].params != `{"arguments":{"q":"go"},"name":"web_search"}` {
+		t.Fatalf("recorded exchanges = %+v", exchanges)
+	}
+
+	// 回放：同样的请求得到录制的结果，_meta 与请求 id 不影响匹配
+	server, err := startReplayServer("search", ReplayConfig{Cassette: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	replay := newTestGateway(t, "/replay", server.URL())
+	rc := dialSSE(t, replay.URL+"/replay/sse", nil)
+	rc.waitEndpoint()
+
+	call := func(body string) *jsonrpcMessage {
+		t.Helper()
+		if code, resp := rc.post(body, nil); code != http.StatusAccepted {
+			t.Fatalf("POST: %d %s", code, resp)
+		}
+		return rc.nextMessage(5 * time.Second)
+	}
+	if msg := call(`{"jsonrpc":"2.0","id":"a","method":"tools/call","params":{"name":"web_search","arguments":{"q":"rust"},"_meta":{"progressToken":"x"}}}`); string(msg.ID) != `"a"` ||
+		string(msg.Result) != `{"content":[{"text":"results for rust","type":"text"}]}` {
+		t.Errorf("replayed rust = %s %s", msg.ID, msg.Result)
+	}
+	if msg := call(`{"jsonrpc":"2.0","id":"b","method":"tools/call","params":{"name":"web_search","arguments":{"q":"go"}}}`); string(msg.Result) != `{"content":[{"text":"results for go","type":"text"}]}` {
+		t.Errorf("replayed go = %s", msg.Result)
+	}
+	// 没有录制的请求得到错误，ping 总是可以应答
+	if msg := call(`{"jsonrpc":"2.0","id":"c","method":"tools/call","params":{"name":"web_search","arguments":{"q":"zig"}}}`); msg.Error == nil || msg.Error.Code != kindNotFound.Code {
+		t.Errorf("unrecorded call = %+v", msg)
+	}
+	if msg := call(`{"jsonrpc":"2.0","id":"d","method":"ping"}`); string(msg.Result) != `{}` {
+		t.Errorf("ping = %+v", msg)
+	}
+}
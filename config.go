@@ -0,0 +1,909 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config 是网关的声明式配置，可由 YAML 或 JSON 文件提供
+// 未出现在文件中的字段沿用环境变量或默认值
+type Config struct {
+	Listeners      []ListenerConfig  `yaml:"listeners"`
+	PublicURL      string            `yaml:"public_url"`
+	TrustedProxies []string          `yaml:"trusted_proxies"`
+	Log            LogConfig         `yaml:"log"`
+	Tracing        TracingConfig     `yaml:"tracing"`
+	HealthCheck    HealthCheckConfig `yaml:"health_check"`
+	Shutdown       ShutdownConfig    `yaml:"shutdown"`
+	Resilience     ResilienceConfig  `yaml:"resilience"`
+	SSE            SSEConfig         `yaml:"sse"`
+	Sessions       SessionsConfig    `yaml:"sessions"`
+	Audit          AuditConfig       `yaml:"audit"`
+	Auth           AuthConfig        `yaml:"auth"`
+	Admin          AdminConfig       `yaml:"admin"`
+	Policies       PolicyConfig      `yaml:"policies"`
+	Quotas         QuotasConfig      `yaml:"quotas"`
+	Cache          CacheConfig       `yaml:"cache"`
+	Routes         []RouteConfig     `yaml:"routes"`
+
+	// 由 TrustedProxies 解析得到，在 validate 中填充
+	trustedNets []netip.Prefix
+}
+
+type ListenerConfig struct {
+	Addr    string `yaml:"addr"`
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter"`
+}
+
+type HealthCheckConfig struct {
+	Interval time.Duration `yaml:"interval"`
+}
+
+// ShutdownConfig 控制优雅关闭时等待进行中 tools/call 的最长时间
+type ShutdownConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// ResilienceConfig 控制访问后端时的熔断与重试
+type ResilienceConfig struct {
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Retry          RetryConfig          `yaml:"retry"`
+}
+
+// CircuitBreakerConfig 是每个后端的熔断器参数，failure_threshold 为 0 时关闭熔断
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failure_threshold"` // 连续失败多少次后熔断
+	OpenTimeout      time.Duration `yaml:"open_timeout"`      // 熔断多久后放行一个探测请求
+}
+
+// RetryConfig 是幂等请求的重试参数，attempts 为总尝试次数，1 表示不重试
+type RetryConfig struct {
+	Attempts int           `yaml:"attempts"`
+	Backoff  time.Duration `yaml:"backoff"` // 首次重试前的等待，之后每次翻倍
+}
+
+// SSEConfig 控制代理 SSE 流的保活与超时，0 表示关闭对应功能
+type SSEConfig struct {
+	KeepAlive           time.Duration `yaml:"keepalive"`             // 流空闲多久后注入注释保活
+	WriteTimeout        time.Duration `yaml:"write_timeout"`         // 单次写客户端的最长时间，超时视为客户端失联
+	UpstreamIdleTimeout time.Duration `yaml:"upstream_idle_timeout"` // 上游多久没有数据视为失联
+	ResumeWindow        time.Duration `yaml:"resume_window"`         // 客户端断开后保留会话等待重连的时间
+	ReplayBuffer        int           `yaml:"replay_buffer"`         // 每个会话为重连保留的最近事件数
+}
+
+// SessionsConfig 控制会话持久化，Store 为共享目录时网关重启或切换副本后客户端仍可接回会话
+type SessionsConfig struct {
+	Store string `yaml:"store"`
+}
+
+type AuditConfig struct {
+	File       string   `yaml:"file"`
+	MaxSizeMB  int      `yaml:"max_size_mb"`
+	MaxBackups int      `yaml:"max_backups"`
+	Redact     []string `yaml:"redact"`
+}
+
+// AuthConfig 定义客户端 API Key，请求通过 Authorization: Bearer、X-API-Key 头或 api_key 查询参数携带
+type AuthConfig struct {
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+}
+
+// AdminConfig 保护 /admin 下的管理接口，未设置 token 时只允许本机访问
+type AdminConfig struct {
+	Token string `yaml:"token"`
+}
+
+// QuotasConfig 配置按租户或 API Key 统计的调用配额，store 为用量文件，为空时用量只保存在内存中
+type QuotasConfig struct {
+	Store string            `yaml:"store"`
+	Rules []QuotaRuleConfig `yaml:"rules"`
+}
+
+// QuotaRuleConfig 是一条配额规则：每个自然日或自然月内，每个租户（或 API Key）最多调用 limit 次匹配的工具
+type QuotaRuleConfig struct {
+	Name        string   `yaml:"name"`
+	Per         string   `yaml:"per"`          // tenant 或 api_key
+	Tenants     []string `yaml:"tenants"`      // 为空时作用于所有租户
+	Routes      []string `yaml:"routes"`       // 路由前缀，为空时统计所有路由
+	Tools       []string `yaml:"tools"`        // 为空时统计所有工具
+	Period      string   `yaml:"period"`       // day 或 month
+	Limit       int64    `yaml:"limit"`        // 周期内允许的调用次数
+	Action      string   `yaml:"action"`       // 超出后的处理：block、warn 或 downgrade
+	DowngradeTo string   `yaml:"downgrade_to"` // action 为 downgrade 时改用的路由前缀
+}
+
+// CacheConfig 是工具结果缓存的存储，缓存哪些工具由路由策略中的 cache 规则决定
+type CacheConfig struct {
+	Backend    string `yaml:"backend"`      // memory 或 disk
+	Dir        string `yaml:"dir"`          // disk 后端保存结果的目录
+	MaxSizeMB  int    `yaml:"max_size_mb"`  // 缓存结果的总大小上限，超出时淘汰最久未使用的结果
+	MaxEntryKB int    `yaml:"max_entry_kb"` // 单个结果的大小上限，更大的结果不缓存
+}
+
+type APIKeyConfig struct {
+	Key    string `yaml:"key"`
+	User   string `yaml:"user"`
+	Tenant string `yaml:"tenant"`
+}
+
+// PolicyConfig 是作用于路由的策略，顶层为默认值，路由中的同名字段覆盖默认值
+type PolicyConfig struct {
+	RequireAuth *bool    `yaml:"require_auth"`
+	AllowTools  []string `yaml:"allow_tools"`
+	DenyTools   []string `yaml:"deny_tools"`
+
+	// 按工具的 inputSchema 校验 tools/call 参数，默认开启
+	ValidateArguments *bool `yaml:"validate_arguments"`
+
+	// tools/call 的超时时间，超时后网关向客户端返回错误并通知后端取消，0 表示不限制
+	CallTimeout  time.Duration            `yaml:"call_timeout"`
+	ToolTimeouts map[string]time.Duration `yaml:"tool_timeouts"` // 按工具名覆盖 call_timeout
+
+	RateLimits []RateLimitConfig `yaml:"rate_limits"`
+	Cache      []CacheRuleConfig `yaml:"cache"`
+}
+
+// CacheRuleConfig 为工具开启结果缓存，相同参数的调用在 ttl 内直接返回缓存的结果
+type CacheRuleConfig struct {
+	Tools   []string      `yaml:"tools"`
+	TTL     time.Duration `yaml:"ttl"`
+	PerUser bool          `yaml:"per_user"` // 按 API Key 分别缓存，结果与调用者相关时开启
+}
+
+// RateLimitConfig 是一条 tools/call 令牌桶限流规则：每个 period 补充 limit 个令牌，桶容量为 burst
+// per 决定令牌桶的划分维度，为空时整条路由共享一个桶
+type RateLimitConfig struct {
+	Name   string        `yaml:"name"`
+	Tools  []string      `yaml:"tools"` // 为空时作用于所有工具
+	Per    []string      `yaml:"per"`   // tool、api_key、client_ip 的组合
+	Limit  int           `yaml:"limit"`
+	Period time.Duration `yaml:"period"`
+	Burst  int           `yaml:"burst"`
+}
+
+type RouteConfig struct {
+	Name      string        `yaml:"name"`
+	Prefix    string        `yaml:"prefix"`
+	Transport string        `yaml:"transport"`
+	Upstream  string        `yaml:"upstream"`
+	Stdio     *StdioConfig  `yaml:"stdio"`
+	Replay    *ReplayConfig `yaml:"replay"`
+	Mock      *MockConfig   `yaml:"mock"`
+	Shadow    *ShadowConfig `yaml:"shadow"`
+	Metadata  RouteMetadata `yaml:"metadata"`
+	Record    string        `yaml:"record"` // 将会话的 JSON-RPC 消息录制到该目录，每个会话一个磁带文件
+	Policies  PolicyConfig  `yaml:"policies"`
+}
+
+// RouteMetadata 是路由的描述信息，来自配置文件或注册请求，在 /overview 中展示并用于生成客户端配置
+type RouteMetadata struct {
+	Version     string           `json:"version,omitempty" yaml:"version"`
+	Tags        []string         `json:"tags,omitempty" yaml:"tags"`
+	Description string           `json:"description,omitempty" yaml:"description"`
+	Owner       string           `json:"owner,omitempty" yaml:"owner"`
+	Contact     string           `json:"contact,omitempty" yaml:"contact"`
+	Transport   string           `json:"transport,omitempty" yaml:"-"` // 后端的传输方式，静态路由取自 transport，注册的后端为 sse
+	Auth        *AuthRequirement `json:"auth,omitempty" yaml:"auth"`
+}
+
+// AuthRequirement 描述客户端访问该服务需要的认证
+type AuthRequirement struct {
+	Required bool   `json:"required" yaml:"required"`
+	Type     string `json:"type,omitempty" yaml:"type"` // 如 api_key、bearer、oauth
+}
+
+// StdioConfig 描述由网关启动的 stdio MCP 服务，每个 SSE 会话启动一个进程
+type StdioConfig struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	Dir     string            `yaml:"dir"`
+}
+
+// ReplayConfig 描述由网关按录制的磁带应答的路由，cassette 为磁带文件或目录
+type ReplayConfig struct {
+	Cassette string `yaml:"cassette"`
+	Realtime bool   `yaml:"realtime"` // 按录制时的耗时延迟响应，默认立即响应
+}
+
+// MockConfig 描述由网关自己应答的模拟 MCP 服务，用于在真实后端就绪前联调
+type MockConfig struct {
+	ServerName    string           `yaml:"server_name"`    // 默认为路由名
+	ServerVersion string           `yaml:"server_version"` // 默认为 mock
+	Instructions  string           `yaml:"instructions"`
+	Tools         []MockToolConfig `yaml:"tools"`
+}
+
+// MockToolConfig 描述一个模拟工具，response 与 result 二选一
+type MockToolConfig struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	InputSchema map[string]any `yaml:"input_schema"` // 默认为不限制参数的 object
+	Response    string         `yaml:"response"`     // text/template 模板，. 为调用参数，渲染结果作为文本内容返回
+	Result      map[string]any `yaml:"result"`       // 原样返回的 CallToolResult
+	IsError     bool           `yaml:"is_error"`     // 与 response 一起使用，返回 isError 结果
+	Delay       time.Duration  `yaml:"delay"`        // 模拟后端耗时
+}
+
+// ShadowConfig 将一部分 tools/call 请求镜像到影子后端，影子的结果只用于对比，不返回给客户端
+type ShadowConfig struct {
+	Upstream string        `yaml:"upstream"` // 影子后端的 SSE 地址
+	Percent  float64       `yaml:"percent"`  // 镜像的请求比例，0-100
+	Tools    []string      `yaml:"tools"`    // 镜像的工具，留空时镜像所有工具
+	Timeout  time.Duration `yaml:"timeout"`  // 影子调用的超时，默认 30s
+}
+
+// 当前生效的配置
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+	currentConfig.Store(defaultConfig())
+}
+
+func config() *Config {
+	return currentConfig.Load()
+}
+
+// defaultConfig 由环境变量生成默认配置，保持未使用配置文件时的行为
+func defaultConfig() *Config {
+	maxSizeMB, _ := strconv.Atoi(getEnv("MCP_GATEWAY_AUDIT_MAX_SIZE_MB", "100"))
+	maxBackups, _ := strconv.Atoi(getEnv("MCP_GATEWAY_AUDIT_MAX_BACKUPS", "10"))
+	replayBuffer, err := strconv.Atoi(getEnv("MCP_GATEWAY_SSE_REPLAY_BUFFER", "100"))
+	if err != nil {
+		replayBuffer = -1
+	}
+	failureThreshold, err := strconv.Atoi(getEnv("MCP_GATEWAY_BREAKER_FAILURES", "5"))
+	if err != nil {
+		failureThreshold = -1
+	}
+	retryAttempts, err := strconv.Atoi(getEnv("MCP_GATEWAY_RETRY_ATTEMPTS", "3"))
+	if err != nil {
+		retryAttempts = -1
+	}
+	cacheMaxSizeMB, err := strconv.Atoi(getEnv("MCP_GATEWAY_CACHE_MAX_SIZE_MB", "64"))
+	if err != nil {
+		cacheMaxSizeMB = -1
+	}
+	cacheMaxEntryKB, err := strconv.Atoi(getEnv("MCP_GATEWAY_CACHE_MAX_ENTRY_KB", "1024"))
+	if err != nil {
+		cacheMaxEntryKB = -1
+	}
+
+	return &Config{
+		Listeners:      []ListenerConfig{{Addr: ":" + getEnv("MCP_GATEWAY_PORT", "3121")}},
+		PublicURL:      getEnv("MCP_GATEWAY_DOMAIN", ""),
+		TrustedProxies: splitList(getEnv("MCP_GATEWAY_TRUSTED_PROXIES", "")),
+		Log: LogConfig{
+			Format: getEnv("MCP_GATEWAY_LOG_FORMAT", "json"),
+			Level:  getEnv("MCP_GATEWAY_LOG_LEVEL", "info"),
+		},
+		Tracing: TracingConfig{
+			Exporter: getEnv("MCP_GATEWAY_TRACE_EXPORTER", "none"),
+		},
+		HealthCheck: HealthCheckConfig{Interval: envDuration("MCP_GATEWAY_HEALTH_INTERVAL", "30s")},
+		Shutdown:    ShutdownConfig{Timeout: envDuration("MCP_GATEWAY_SHUTDOWN_TIMEOUT", "30s")},
+		Resilience: ResilienceConfig{
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: failureThreshold,
+				OpenTimeout:      envDuration("MCP_GATEWAY_BREAKER_OPEN_TIMEOUT", "30s"),
+			},
+			Retry: RetryConfig{
+				Attempts: retryAttempts,
+				Backoff:  envDuration("MCP_GATEWAY_RETRY_BACKOFF", "200ms"),
+			},
+		},
+		SSE: SSEConfig{
+			KeepAlive:           envDuration("MCP_GATEWAY_SSE_KEEPALIVE", "15s"),
+			WriteTimeout:        envDuration("MCP_GATEWAY_SSE_WRITE_TIMEOUT", "30s"),
+			UpstreamIdleTimeout: envDuration("MCP_GATEWAY_SSE_UPSTREAM_IDLE_TIMEOUT", "0"),
+			ResumeWindow:        envDuration("MCP_GATEWAY_SSE_RESUME_WINDOW", "30s"),
+			ReplayBuffer:        replayBuffer,
+		},
+		Sessions: SessionsConfig{
+			Store: getEnv("MCP_GATEWAY_SESSION_STORE", ""),
+		},
+		Policies: PolicyConfig{
+			CallTimeout: envDuration("MCP_GATEWAY_CALL_TIMEOUT", "0"),
+		},
+		Quotas: QuotasConfig{
+			Store: getEnv("MCP_GATEWAY_USAGE_STORE", ""),
+		},
+		Cache: CacheConfig{
+			Backend:    getEnv("MCP_GATEWAY_CACHE_BACKEND", "memory"),
+			Dir:        getEnv("MCP_GATEWAY_CACHE_DIR", ""),
+			MaxSizeMB:  cacheMaxSizeMB,
+			MaxEntryKB: cacheMaxEntryKB,
+		},
+		Audit: AuditConfig{
+			File:       getEnv("MCP_GATEWAY_AUDIT_FILE", ""),
+			MaxSizeMB:  maxSizeMB,
+			MaxBackups: maxBackups,
+			Redact:     splitList(getEnv("MCP_GATEWAY_AUDIT_REDACT", "")),
+		},
+	}
+}
+
+// envDuration 解析时长类型的环境变量，格式错误时返回 -1 交给 validate 报错
+func envDuration(key, fallback string) time.Duration {
+	d, err := time.ParseDuration(getEnv(key, fallback))
+	if err != nil {
+		return -1
+	}
+	return d
+}
+
+// splitList 拆分逗号分隔的环境变量，忽略空项
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadConfig 读取并校验配置文件，path 为空时只使用环境变量
+func loadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		if err := parseConfig(data, cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseConfig 展开环境变量后将配置解析到 cfg 上，未知字段视为错误
+func parseConfig(data []byte, cfg *Config) error {
+	expanded, err := expandEnv(data)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(expanded))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+var envPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// expandEnv 展开 ${VAR} 与 ${VAR:-default}，$$ 表示字面量 $
+// 未设置且没有默认值的变量视为错误，避免密钥缺失时静默使用空值
+func expandEnv(data []byte) ([]byte, error) {
+	var missing []string
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	for i, line := range lines {
+		// 整行注释不展开，示例与说明中可以直接书写 ${VAR}
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("#")) {
+			continue
+		}
+		lines[i] = envPattern.ReplaceAllFunc(line, func(match []byte) []byte {
+			if string(match) == "$$" {
+				return []byte("$")
+			}
+			groups := envPattern.FindSubmatch(match)
+			if value, ok := os.LookupEnv(string(groups[1])); ok {
+				return []byte(value)
+			}
+			if groups[2] != nil {
+				return groups[3]
+			}
+			missing = append(missing, fmt.Sprintf("${%s} (line %d)", groups[1], i+1))
+			return nil
+		})
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return bytes.Join(lines, nil), nil
+}
+
+// 网关自身占用的路径，不能作为路由前缀
+var reservedPrefixes = map[string]bool{
+	"/overview": true,
+	"/register": true,
+	"/metrics":  true,
+	"/admin":    true,
+}
+
+// validate 校验配置并补全派生字段，返回所有错误
+func (c *Config) validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("config: %s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if len(c.Listeners) == 0 {
+		fail("listeners", "at least one listener is required")
+	}
+	for i, l := range c.Listeners {
+		if l.Addr == "" {
+			fail(fmt.Sprintf("listeners[%d].addr", i), "must not be empty")
+		}
+		if (l.TLSCert == "") != (l.TLSKey == "") {
+			fail(fmt.Sprintf("listeners[%d]", i), "tls_cert and tls_key must be set together")
+		}
+	}
+
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("public_url", "must be an absolute http(s) URL, got %q", c.PublicURL)
+		} else if u.RawQuery != "" || u.Fragment != "" {
+			fail("public_url", "must not contain a query or fragment, got %q", c.PublicURL)
+		}
+	}
+	c.trustedNets = nil
+	for i, proxy := range c.TrustedProxies {
+		// 支持单个地址与 CIDR
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(proxy)
+			if addrErr != nil {
+				fail(fmt.Sprintf("trusted_proxies[%d]", i), "must be an IP address or CIDR, got %q", proxy)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+		}
+		c.trustedNets = append(c.trustedNets, prefix.Masked())
+	}
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		fail("log.level", "%v", err)
+	}
+	if _, err := newLogger(io.Discard, c.Log.Format, logLevel); err != nil {
+		fail("log.format", "%v", err)
+	}
+	switch c.Tracing.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		fail("tracing.exporter", "must be one of none, stdout, otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.HealthCheck.Interval <= 0 {
+		fail("health_check.interval", "must be a positive duration")
+	}
+	if c.Shutdown.Timeout <= 0 {
+		fail("shutdown.timeout", "must be a positive duration")
+	}
+	if c.Resilience.CircuitBreaker.FailureThreshold < 0 {
+		fail("resilience.circuit_breaker.failure_threshold", "must not be negative")
+	}
+	if c.Resilience.CircuitBreaker.FailureThreshold > 0 && c.Resilience.CircuitBreaker.OpenTimeout <= 0 {
+		fail("resilience.circuit_breaker.open_timeout", "must be a positive duration")
+	}
+	if c.Resilience.Retry.Attempts < 1 {
+		fail("resilience.retry.attempts", "must be at least 1")
+	}
+	if c.Resilience.Retry.Backoff < 0 {
+		fail("resilience.retry.backoff", "must not be negative")
+	}
+	if c.SSE.KeepAlive < 0 {
+		fail("sse.keepalive", "must not be negative")
+	}
+	if c.SSE.WriteTimeout < 0 {
+		fail("sse.write_timeout", "must not be negative")
+	}
+	if c.SSE.UpstreamIdleTimeout < 0 {
+		fail("sse.upstream_idle_timeout", "must not be negative")
+	}
+	if c.SSE.ResumeWindow < 0 {
+		fail("sse.resume_window", "must not be negative")
+	}
+	if c.SSE.ReplayBuffer < 0 {
+		fail("sse.replay_buffer", "must not be negative")
+	}
+	if _, err := parseRedactRules(c.Audit.Redact); err != nil {
+		fail("audit.redact", "%v", err)
+	}
+	if c.Audit.MaxSizeMB < 0 || c.Audit.MaxBackups < 0 {
+		fail("audit", "max_size_mb and max_backups must not be negative")
+	}
+
+	keys := map[string]bool{}
+	for i, k := range c.Auth.APIKeys {
+		field := fmt.Sprintf("auth.api_keys[%d]", i)
+		if k.Key == "" {
+			fail(field+".key", "must not be empty")
+		}
+		if keys[k.Key] {
+			fail(field+".key", "duplicate key")
+		}
+		keys[k.Key] = true
+		if k.User == "" {
+			fail(field+".user", "must not be empty")
+		}
+		if k.Tenant == anonymousSubject {
+			fail(field+".tenant", "%q is reserved for unauthenticated calls", anonymousSubject)
+		}
+	}
+	if c.Policies.RequireAuth != nil && *c.Policies.RequireAuth && len(c.Auth.APIKeys) == 0 {
+		fail("policies.require_auth", "requires auth.api_keys")
+	}
+	validateTimeouts("policies", c.Policies, fail)
+	validateRateLimits("policies.rate_limits", c.Policies.RateLimits, fail)
+	validateCacheRules("policies.cache", c.Policies.Cache, fail)
+
+	switch c.Cache.Backend {
+	case "memory":
+	case "disk":
+		if c.Cache.Dir == "" {
+			fail("cache.dir", "is required for backend disk")
+		}
+	default:
+		fail("cache.backend", "must be memory or disk, got %q", c.Cache.Backend)
+	}
+	if c.Cache.MaxSizeMB <= 0 {
+		fail("cache.max_size_mb", "must be positive")
+	}
+	if c.Cache.MaxEntryKB <= 0 {
+		fail("cache.max_entry_kb", "must be positive")
+	}
+
+	prefixes := map[string]bool{}
+	for i := range c.Routes {
+		r := &c.Routes[i]
+		field := fmt.Sprintf("routes[%d]", i)
+
+		if r.Name == "" || strings.Contains(r.Name, "/") {
+			fail(field+".name", "must be a non-empty name without '/'")
+		}
+		if r.Prefix == "" {
+			r.Prefix = "/" + r.Name
+		}
+		r.Prefix = "/" + strings.Trim(r.Prefix, "/")
+		if r.Prefix == "/" || reservedPrefixes[r.Prefix] {
+			fail(field+".prefix", "%q is reserved", r.Prefix)
+		}
+		if prefixes[r.Prefix] {
+			fail(field+".prefix", "duplicate prefix %q", r.Prefix)
+		}
+		prefixes[r.Prefix] = true
+
+		if r.Transport == "" {
+			r.Transport = "sse"
+		}
+		switch r.Transport {
+		case "sse":
+			if u, err := url.Parse(r.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail(field+".upstream", "must be an http(s) URL, got %q", r.Upstream)
+			}
+		case "stdio":
+			if r.Stdio == nil || r.Stdio.Command == "" {
+				fail(field+".stdio.command", "is required for transport stdio")
+			}
+		case "replay":
+			if r.Replay == nil || r.Replay.Cassette == "" {
+				fail(field+".replay.cassette", "is required for transport replay")
+			}
+		case "mock":
+			if r.Mock == nil || len(r.Mock.Tools) == 0 {
+				fail(field+".mock.tools", "is required for transport mock")
+			} else {
+				validateMock(field+".mock", r.Mock, fail)
+			}
+		default:
+			fail(field+".transport", "must be sse, stdio, replay or mock, got %q", r.Transport)
+		}
+		// 其他传输方式的字段不允许出现
+		if r.Upstream != "" && r.Transport != "sse" {
+			fail(field+".upstream", "not allowed with transport %s", r.Transport)
+		}
+		if r.Stdio != nil && r.Transport != "stdio" {
+			fail(field+".stdio", "only allowed with transport stdio")
+		}
+		if r.Replay != nil && r.Transport != "replay" {
+			fail(field+".replay", "only allowed with transport replay")
+		}
+		if r.Mock != nil && r.Transport != "mock" {
+			fail(field+".mock", "only allowed with transport mock")
+		}
+		for j, tag := range r.Metadata.Tags {
+			if strings.TrimSpace(tag) == "" {
+				fail(fmt.Sprintf("%s.metadata.tags[%d]", field, j), "must not be empty")
+			}
+		}
+		if r.Shadow != nil {
+			validateShadow(field+".shadow", r.Shadow, fail)
+		}
+		if r.Policies.RequireAuth != nil && *r.Policies.RequireAuth && len(c.Auth.APIKeys) == 0 {
+			fail(field+".policies.require_auth", "requires auth.api_keys")
+		}
+		validateTimeouts(field+".policies", r.Policies, fail)
+		validateRateLimits(field+".policies.rate_limits", r.Policies.RateLimits, fail)
+		validateCacheRules(field+".policies.cache", r.Policies.Cache, fail)
+	}
+
+	names := map[string]bool{}
+	for i := range c.Quotas.Rules {
+		rule := &c.Quotas.Rules[i]
+		field := fmt.Sprintf("quotas.rules[%d]", i)
+		if rule.Name == "" {
+			rule.Name = strconv.Itoa(i)
+		}
+		if names[rule.Name] {
+			fail(field+".name", "duplicate name %q", rule.Name)
+		}
+		names[rule.Name] = true
+		switch rule.Per {
+		case "":
+			rule.Per = "tenant"
+		case "tenant", "api_key":
+		default:
+			fail(field+".per", "must be tenant or api_key, got %q", rule.Per)
+		}
+		for j, route := range rule.Routes {
+			rule.Routes[j] = "/" + strings.Trim(route, "/")
+		}
+		switch rule.Period {
+		case "day", "month":
+		default:
+			fail(field+".period", "must be day or month, got %q", rule.Period)
+		}
+		if rule.Limit <= 0 {
+			fail(field+".limit", "must be positive")
+		}
+		switch rule.Action {
+		case "":
+			rule.Action = "block"
+		case "block", "warn":
+		case "downgrade":
+			rule.DowngradeTo = "/" + strings.Trim(rule.DowngradeTo, "/")
+			if target := c.routeByPrefix(rule.DowngradeTo); target == nil {
+				fail(field+".downgrade_to", "must be a route prefix, got %q", rule.DowngradeTo)
+			} else if len(rule.Routes) == 0 || slices.Contains(rule.Routes, target.Prefix) {
+				fail(field+".downgrade_to", "must not be one of the routes the rule applies to")
+			}
+		default:
+			fail(field+".action", "must be block, warn or downgrade, got %q", rule.Action)
+		}
+		if rule.Action != "downgrade" && rule.DowngradeTo != "" {
+			fail(field+".downgrade_to", "only allowed with action downgrade")
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateTimeouts 校验策略中的工具调用超时
+func validateTimeouts(field string, policy PolicyConfig, fail func(field, format string, args ...any)) {
+	if policy.CallTimeout < 0 {
+		fail(field+".call_timeout", "must not be negative")
+	}
+	for tool, timeout := range policy.ToolTimeouts {
+		if timeout < 0 {
+			fail(field+".tool_timeouts."+tool, "must not be negative")
+		}
+	}
+}
+
+// validateRateLimits 校验限流规则并填充默认值：period 默认 1s，burst 默认等于 limit，name 默认为规则序号
+func validateRateLimits(field string, rules []RateLimitConfig, fail func(field, format string, args ...any)) {
+	names := map[string]bool{}
+	for i := range rules {
+		rule := &rules[i]
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if rule.Name == "" {
+			rule.Name = strconv.Itoa(i)
+		}
+		if names[rule.Name] {
+			fail(f+".name", "duplicate name %q", rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Limit <= 0 {
+			fail(f+".limit", "must be positive")
+		}
+		if rule.Period == 0 {
+			rule.Period = time.Second
+		}
+		if rule.Period < 0 {
+			fail(f+".period", "must be a positive duration")
+		}
+		if rule.Burst == 0 {
+			rule.Burst = rule.Limit
+		}
+		if rule.Burst < 0 {
+			fail(f+".burst", "must not be negative")
+		}
+		for _, per := range rule.Per {
+			switch per {
+			case "tool", "api_key", "client_ip":
+			default:
+				fail(f+".per", "must be tool, api_key or client_ip, got %q", per)
+			}
+		}
+	}
+}
+
+// validateCacheRules 校验结果缓存规则，缓存需要按工具显式开启
+func validateCacheRules(field string, rules []CacheRuleConfig, fail func(field, format string, args ...any)) {
+	for i, rule := range rules {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		if len(rule.Tools) == 0 {
+			fail(f+".tools", "must list the tools to cache")
+		}
+		if rule.TTL <= 0 {
+			fail(f+".ttl", "must be a positive duration")
+		}
+	}
+}
+
+// validateMock 校验模拟服务的工具定义与响应模板
+func validateMock(field string, mock *MockConfig, fail func(field, format string, args ...any)) {
+	names := map[string]bool{}
+	for i, tool := range mock.Tools {
+		f := fmt.Sprintf("%s.tools[%d]", field, i)
+		if tool.Name == "" {
+			fail(f+".name", "is required")
+		} else if names[tool.Name] {
+			fail(f+".name", "duplicate tool %q", tool.Name)
+		}
+		names[tool.Name] = true
+		if tool.Response != "" && tool.Result != nil {
+			fail(f+".result", "not allowed together with response")
+		}
+		if tool.IsError && tool.Result != nil {
+			fail(f+".is_error", "not allowed together with result, set isError in the result instead")
+		}
+		if _, err := parseMockTemplate(tool); err != nil {
+			fail(f+".response", "%v", err)
+		}
+		if tool.Delay < 0 {
+			fail(f+".delay", "must not be negative")
+		}
+	}
+}
+
+// validateShadow 校验流量镜像配置并填充默认超时
+func validateShadow(field string, shadow *ShadowConfig, fail func(field, format string, args ...any)) {
+	if u, err := url.Parse(shadow.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail(field+".upstream", "must be an http(s) URL, got %q", shadow.Upstream)
+	}
+	if shadow.Percent <= 0 || shadow.Percent > 100 {
+		fail(field+".percent", "must be in (0, 100], got %g", shadow.Percent)
+	}
+	if shadow.Timeout < 0 {
+		fail(field+".timeout", "must not be negative")
+	}
+	if shadow.Timeout == 0 {
+		shadow.Timeout = defaultShadowTimeout
+	}
+}
+
+// routeByPrefix 返回配置中声明的静态路由，动态注册的路由返回 nil
+func (c *Config) routeByPrefix(prefix string) *RouteConfig {
+	for i := range c.Routes {
+		if c.Routes[i].Prefix == prefix {
+			return &c.Routes[i]
+		}
+	}
+	return nil
+}
+
+// policyFor 合并默认策略与路由策略，带版本的前缀使用其名称的路由策略，各版本不能放宽名称的限制
+func (c *Config) policyFor(prefix string) PolicyConfig {
+	policy := c.Policies
+	route := c.routeByPrefix(routeName(prefix))
+	if route == nil {
+		return policy
+	}
+	if route.Policies.RequireAuth != nil {
+		policy.RequireAuth = route.Policies.RequireAuth
+	}
+	if route.Policies.AllowTools != nil {
+		policy.AllowTools = route.Policies.AllowTools
+	}
+	if route.Policies.DenyTools != nil {
+		policy.DenyTools = route.Policies.DenyTools
+	}
+	if route.Policies.ValidateArguments != nil {
+		policy.ValidateArguments = route.Policies.ValidateArguments
+	}
+	if route.Policies.CallTimeout != 0 {
+		policy.CallTimeout = route.Policies.CallTimeout
+	}
+	if route.Policies.ToolTimeouts != nil {
+		// 按工具合并，路由中的设置优先
+		merged := make(map[string]time.Duration, len(policy.ToolTimeouts)+len(route.Policies.ToolTimeouts))
+		for tool, timeout := range policy.ToolTimeouts {
+			merged[tool] = timeout
+		}
+		for tool, timeout := range route.Policies.ToolTimeouts {
+			merged[tool] = timeout
+		}
+		policy.ToolTimeouts = merged
+	}
+	if route.Policies.RateLimits != nil {
+		policy.RateLimits = route.Policies.RateLimits
+	}
+	if route.Policies.Cache != nil {
+		policy.Cache = route.Policies.Cache
+	}
+	return policy
+}
+
+// lookupAPIKey 查找 API Key，不存在时返回 nil
+func (c *Config) lookupAPIKey(key string) *APIKeyConfig {
+	if key == "" {
+		return nil
+	}
+	for i := range c.Auth.APIKeys {
+		if c.Auth.APIKeys[i].Key == key {
+			return &c.Auth.APIKeys[i]
+		}
+	}
+	return nil
+}
+
+// toolAllowed 判断策略是否允许调用该工具
+func (p PolicyConfig) toolAllowed(tool string) bool {
+	for _, t := range p.DenyTools {
+		if t == tool {
+			return false
+		}
+	}
+	if len(p.AllowTools) == 0 {
+		return true
+	}
+	for _, t := range p.AllowTools {
+		if t == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// callTimeout 返回工具调用的超时时间，0 表示不限制
+func (p PolicyConfig) callTimeout(tool string) time.Duration {
+	if timeout, ok := p.ToolTimeouts[tool]; ok {
+		return timeout
+	}
+	return p.CallTimeout
+}
+
+// validatesArguments 判断是否校验 tools/call 参数，未设置时默认校验
+func (p PolicyConfig) validatesArguments() bool {
+	return p.ValidateArguments == nil || *p.ValidateArguments
+}
+
+// cacheRule 返回工具适用的结果缓存规则，没有开启缓存时返回 nil
+func (p PolicyConfig) cacheRule(tool string) *CacheRuleConfig {
+	for i := range p.Cache {
+		if slices.Contains(p.Cache[i].Tools, tool) {
+			return &p.Cache[i]
+		}
+	}
+	return nil
+}
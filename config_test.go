@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useConfig 在测试期间替换当前配置
+func useConfig(t *testing.T, cfg *Config) {
+	prev := currentConfig.Swap(cfg)
+	t.Cleanup(func() { currentConfig.Store(prev) })
+}
+
+// parseTestConfig 在默认配置上解析并校验 YAML
+func parseTestConfig(t *testing.T, data string) (*Config, error) {
+	t.Helper()
+	cfg := defaultConfig()
+	if err := parseConfig([]byte(data), cfg); err != nil {
+		return nil, err
+	}
+	return cfg, cfg.validate()
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("GW_TEST_KEY", "secret")
+	out, err := expandEnv([]byte("key: ${GW_TEST_KEY}\nurl: ${GW_TEST_UNSET:-http://localhost}\nprice: $$5\n# ${GW_TEST_MISSING}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "key: secret\nurl: http://localhost\nprice: $5\n# ${GW_TEST_MISSING}\n"; string(out) != want {
+		t.Errorf("expandEnv = %q, want %q", out, want)
+	}
+
+	// 未设置且没有默认值的变量逐个报告所在行
+	_, err = expandEnv([]byte("a: 1\nkey: ${GW_TEST_MISSING}\n"))
+	if err == nil || !strings.Contains(err.Error(), "${GW_TEST_MISSING} (line 2)") {
+		t.Errorf("missing variable: err %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg, err := parseTestConfig(t, `
+routes:
+  - name: search
+    upstream: http://127.0.0.1:8080/sse
+  - name: files
+    prefix: /fs/
+    transport: stdio
+    stdio: {command: files-server}
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := cfg.Routes[0]; r.Prefix != "/search" || r.Transport != "sse" {
+		t.Errorf("route defaults: %+v", r)
+	}
+	if cfg.routeByPrefix("/fs") == nil || cfg.routeByPrefix("/files") != nil {
+		t.Error("prefix not normalized")
+	}
+
+	tests := []struct {
+		name, yaml, want string
+	}{
+		{"unknown field", "listner: []", "field listner not found"},
+		{"reserved prefix", "routes: [{name: metrics, upstream: 'http://a/sse'}]", `"/metrics" is reserved`},
+		{"duplicate prefix", "routes: [{name: a, prefix: /x, upstream: 'http://a/sse'}, {name: b, prefix: /x/, upstream: 'http://b/sse'}]", "duplicate prefix"},
+		{"sse without upstream", "routes: [{name: a}]", "must be an http(s) URL"},
+		{"stdio without command", "routes: [{name: a, transport: stdio}]", "stdio.command: is required"},
+		{"auth without keys", "policies: {require_auth: true}", "requires auth.api_keys"},
+		{"duplicate key", "auth: {api_keys: [{key: k, user: a}, {key: k, user: b}]}", "duplicate key"},
+		{"bad log level", "log: {level: verbose}", "log.level"},
+		{"bad redact rule", "audit: {redact: [password]}", "audit.redact"},
+	}
+	for _, tt := range tests {
+		_, err := parseTestConfig(t, tt.yaml)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPolicyFor(t *testing.T) {
+	cfg, err := parseTestConfig(t, `
+auth:
+  api_keys: [{key: k, user: alice}]
+policies:
+  require_auth: true
+  deny_tools: [delete_all]
+routes:
+  - name: public
+    upstream: http://127.0.0.1:1/sse
+    policies: {require_auth: false, allow_tools: [web_search]}
+  - name: internal
+    upstream: http://127.0.0.1:2/sse
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public := cfg.policyFor("/public")
+	if *public.RequireAuth || !public.toolAllowed("web_search") || public.toolAllowed("fetch") || public.toolAllowed("delete_all") {
+		t.Errorf("public policy = %+v", public)
+	}
+	// 未覆盖的字段沿用默认策略，动态路由只使用默认策略
+	for _, prefix := range []string{"/internal", "/dynamic"} {
+		p := cfg.policyFor(prefix)
+		if !*p.RequireAuth || !p.toolAllowed("fetch") || p.toolAllowed("delete_all") {
+			t.Errorf("%s policy = %+v", prefix, p)
+		}
+	}
+}
+
+func TestLoadExampleConfig(t *testing.T) {
+	if _, err := loadConfig("gateway.example.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	os.WriteFile(path, []byte(`{"public_url": "https://mcp.example.com", "routes": [{"name": "search", "upstream": "http://127.0.0.1:1/sse"}]}`), 0o600)
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PublicURL != "https://mcp.example.com" || len(cfg.Routes) != 1 {
+		t.Errorf("json config = %+v", cfg)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown 为 true 时网关不再接受新会话与新的 tools/call
+var shuttingDown atomic.Bool
+
+// 处于排空状态的路由，不再接受新会话，已有会话继续工作
+var (
+	drainingRoutes     = map[string]bool{}
+	drainingRoutesLock = sync.RWMutex{}
+)
+
+func isRouteDraining(prefix string) bool {
+	drainingRoutesLock.RLock()
+	defer drainingRoutesLock.RUnlock()
+	return drainingRoutes[prefix]
+}
+
+func setRouteDraining(prefix string, draining bool) {
+	drainingRoutesLock.Lock()
+	defer drainingRoutesLock.Unlock()
+	if draining {
+		drainingRoutes[prefix] = true
+	} else {
+		delete(drainingRoutes, prefix)
+	}
+}
+
+// 排空中间件：网关关闭或路由排空时拒绝新的 SSE 连接，客户端可稍后重连到其他实例
+func drainMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix, _ := r.Context().Value(prefixKey).(string)
+		newSession := r.Method == http.MethodGet && r.URL.Query().Get("sessionId") == ""
+		// 路由排空时已有会话的重连不受影响，网关关闭时一律拒绝
+		if newSession && isRouteDraining(prefix) && !shuttingDown.Load() {
+			if id, _, _ := reattachTarget(r); id != "" && lookupSession(prefix, id) != nil {
+				newSession = false
+			}
+		}
+		if newSession && (shuttingDown.Load() || isRouteDraining(prefix)) {
+			w.Header().Set("Retry-After", "5")
+			writeError(w, r, kindUnavailable, "route is draining, retry later", map[string]any{"retry_after": 5})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// waitInFlight 等待路由（为空时为全部路由）上的 tools/call 完成，返回截止时仍未完成的数量
+func waitInFlight(route string, deadline time.Time) int {
+	for {
+		inFlight := 0
+		for _, s := range sessionsSnapshot(route) {
+			inFlight += s.inFlightCalls()
+		}
+		if inFlight == 0 || time.Now().After(deadline) {
+			return inFlight
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// gracefulShutdown 停止接受新会话，通知客户端，等待进行中的调用完成后关闭服务器
+func gracefulShutdown(servers []*http.Server, timeout time.Duration) {
+	shuttingDown.Store(true)
+	deadline := time.Now().Add(timeout)
+
+	sessions := sessionsSnapshot("")
+	logger.Info("shutting down", "sessions", len(sessions), "timeout", timeout.String())
+	for _, s := range sessions {
+		s.notify("notifications/message", map[string]any{
+			"level":  "warning",
+			"logger": "mcp-gateway",
+			"data":   "gateway is shutting down, please reconnect",
+		})
+	}
+
+	if remaining := waitInFlight("", deadline); remaining > 0 {
+		logger.Warn("shutdown deadline reached with tool calls in flight", "in_flight", remaining)
+	}
+
+	// 结束所有 SSE 流，服务器随后即可关闭空闲连接
+	// 启用会话存储时保留会话记录，客户端可以在网关重启后接回
+	for _, s := range sessionsSnapshot("") {
+		if sessionStore.Load() != nil {
+			s.suspend()
+		} else {
+			s.terminate()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warn("server shutdown incomplete", "addr", server.Addr, "error", err)
+		}
+	}
+
+	routeMapLock.Lock()
+	for prefix, server := range localServers {
+		server.Close()
+		delete(localServers, prefix)
+	}
+	routeMapLock.Unlock()
+	auditor.Swap(nil).Close()
+	if path := config().Quotas.Store; path != "" {
+		if err := usage.save(path); err != nil {
+			logger.Warn("failed to save usage", "path", path, "error", err)
+		}
+	}
+	logger.Info("shutdown complete")
+}
+
+// routeDrainStatus 是 /admin/routes/drain 的响应
+type routeDrainStatus struct {
+	Route    string `json:"route"`
+	Draining bool   `json:"draining"`
+	Sessions int    `json:"sessions"`
+	InFlight int    `json:"in_flight"`
+}
+
+// AdminRouteDrain 管理路由排空：POST 开始排空，DELETE 恢复，GET 查询剩余会话与进行中的调用
+func AdminRouteDrain(w http.ResponseWriter, r *http.Request) {
+	route := r.URL.Query().Get("route")
+	if route == "" {
+		writeError(w, r, kindBadRequest, "missing route parameter", nil)
+		return
+	}
+	route = "/" + strings.Trim(route, "/")
+	if _, ok := getRoutes()[route]; !ok {
+		writeError(w, r, kindNotFound, "unknown route", map[string]any{"route": route})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		setRouteDraining(route, true)
+		logger.Info("route draining", "route", route)
+	case http.MethodDelete:
+		setRouteDraining(route, false)
+		logger.Info("route drain cancelled", "route", route)
+	case http.MethodGet:
+	default:
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	status := routeDrainStatus{Route: route, Draining: isRouteDraining(route)}
+	for _, s := range sessionsSnapshot(route) {
+		status.Sessions++
+		status.InFlight += s.inFlightCalls()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
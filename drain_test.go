@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addSession 将会话登记到会话表，测试结束后删除
+func addSession(t *testing.T, s *mcpSession) {
+	sessionMapLock.Lock()
+	sessionMap[sessionMapKey(s.Prefix, s.ID)] = s
+	sessionMapLock.Unlock()
+	t.Cleanup(func() {
+		sessionMapLock.Lock()
+		delete(sessionMap, sessionMapKey(s.Prefix, s.ID))
+		sessionMapLock.Unlock()
+	})
+}
+
+func TestDrainMiddleware(t *testing.T) {
+	handler := drainMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, nil)
+		r = r.WithContext(context.WithValue(r.Context(), prefixKey, "/search"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	if rec := serve(http.MethodGet, "/search/sse"); rec.Code != http.StatusOK {
+		t.Fatalf("new session before drain: %d", rec.Code)
+	}
+
+	setRouteDraining("/search", true)
+	t.Cleanup(func() { setRouteDraining("/search", false) })
+	rec := serve(http.MethodGet, "/search/sse")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("new session while draining: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	// 已有会话的消息照常转发
+	if rec := serve(http.MethodPost, "/search/message?sessionId=s1"); rec.Code != http.StatusOK {
+		t.Errorf("message while draining: %d", rec.Code)
+	}
+
+	setRouteDraining("/search", false)
+	shuttingDown.Store(true)
+	t.Cleanup(func() { shuttingDown.Store(false) })
+	if rec := serve(http.MethodGet, "/search/sse"); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("new session while shutting down: %d", rec.Code)
+	}
+}
+
+func TestAdminRouteDrain(t *testing.T) {
+	resetRoutes(t)
+	routeMapLock.Lock()
+	routeMap["/search"] = "http://127.0.0.1:1/sse"
+	routeMapLock.Unlock()
+	t.Cleanup(func() { setRouteDraining("/search", false) })
+
+	s := &mcpSession{ID: "s1", Prefix: "/search", pending: map[string]*pendingCall{
+		"1": {Method: "tools/call", Tool: "web_search", Started: time.Now()},
+		"2": {Method: "tools/list", Started: time.Now()},
+	}}
+	addSession(t, s)
+
+	serve := func(method, route string) (int, routeDrainStatus) {
+		rec := httptest.NewRecorder()
+		AdminRouteDrain(rec, httptest.NewRequest(method, "/admin/routes/drain?route="+route, nil))
+		var status routeDrainStatus
+		json.NewDecoder(rec.Body).Decode(&status)
+		return rec.Code, status
+	}
+
+	code, status := serve(http.MethodPost, "search")
+	if code != http.StatusOK || !status.Draining || status.Sessions != 1 || status.InFlight != 1 {
+		t.Errorf("POST: %d %+v", code, status)
+	}
+	if !isRouteDraining("/search") {
+		t.Error("route not draining")
+	}
+	if remaining := waitInFlight("/search", time.Now()); remaining != 1 {
+		t.Errorf("waitInFlight = %d, want 1", remaining)
+	}
+
+	code, status = serve(http.MethodDelete, "/search/")
+	if code != http.StatusOK || status.Draining {
+		t.Errorf("DELETE: %d %+v", code, status)
+	}
+	if code, _ := serve(http.MethodGet, "missing"); code != http.StatusNotFound {
+		t.Errorf("unknown route: %d", code)
+	}
+	rec := httptest.NewRecorder()
+	AdminRouteDrain(rec, httptest.NewRequest(http.MethodGet, "/admin/routes/drain", strings.NewReader("")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing route: %d", rec.Code)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// errorKind 是网关自身产生的一类错误：type 是 JSON 错误体中的稳定标识，
+// code 是消息通道上的 JSON-RPC 错误码（自定义错误码位于规范留给服务端实现的 -32000 ~ -32099 区间），status 是 HTTP 状态码
+type errorKind struct {
+	Type   string
+	Code   int
+	Status int
+}
+
+var (
+	kindParseError          = errorKind{"parse_error", mcp.PARSE_ERROR, http.StatusBadRequest}
+	kindBadRequest          = errorKind{"bad_request", mcp.INVALID_REQUEST, http.StatusBadRequest}
+	kindInvalidParams       = errorKind{"invalid_params", mcp.INVALID_PARAMS, http.StatusBadRequest}
+	kindMethodNotAllowed    = errorKind{"method_not_allowed", mcp.METHOD_NOT_FOUND, http.StatusMethodNotAllowed}
+	kindUnauthorized        = errorKind{"unauthorized", -32001, http.StatusUnauthorized}
+	kindUnknownServer       = errorKind{"unknown_server", -32002, http.StatusNotFound}
+	kindForbidden           = errorKind{"forbidden", -32003, http.StatusForbidden}
+	kindNotFound            = errorKind{"not_found", -32004, http.StatusNotFound}
+	kindUpstreamUnavailable = errorKind{"upstream_unavailable", -32010, http.StatusBadGateway}
+	kindUnavailable         = errorKind{"unavailable", -32011, http.StatusServiceUnavailable}
+	kindTimeout             = errorKind{"timeout", -32012, http.StatusGatewayTimeout}
+	kindRateLimited         = errorKind{"rate_limited", -32029, http.StatusTooManyRequests}
+	kindQuotaExceeded       = errorKind{"quota_exceeded", -32030, http.StatusTooManyRequests}
+	kindInternal            = errorKind{"internal_error", mcp.INTERNAL_ERROR, http.StatusInternalServerError}
+)
+
+// messageContextKey 保存 messageMiddleware 解析出的 JSON-RPC 请求及所属会话
+const messageContextKey contextKey = "message"
+
+// messageContext 让后续的错误处理无需再次读取请求体，也不依赖已改写为后端 id 的 sessionId
+type messageContext struct {
+	msg     *jsonrpcMessage
+	session *mcpSession
+}
+
+func withMessage(ctx context.Context, msg *jsonrpcMessage, session *mcpSession) context.Context {
+	return context.WithValue(ctx, messageContextKey, &messageContext{msg: msg, session: session})
+}
+
+// requestMessage 返回请求携带的 JSON-RPC 消息及所属会话，不是 JSON-RPC 消息时返回 nil
+// 尚未经过 messageMiddleware 的 POST 请求会读取请求体并原样放回
+func requestMessage(r *http.Request) (*jsonrpcMessage, *mcpSession) {
+	if mc, ok := r.Context().Value(messageContextKey).(*messageContext); ok {
+		return mc.msg, mc.session
+	}
+	if r.Method != http.MethodPost || r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageBodySize))
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, nil
+	}
+	msg, ok := parseJSONRPC(body)
+	if !ok || msg.Method == "" {
+		return nil, nil
+	}
+	prefix, _ := r.Context().Value(prefixKey).(string)
+	return msg, lookupSession(prefix, r.URL.Query().Get("sessionId"))
+}
+
+// errorBody 是非 JSON-RPC 请求的错误响应体
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Type      string         `json:"type"`
+	Code      int            `json:"code"`
+	Message   string         `json:"message"`
+	RequestID string         `json:"request_id"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// writeError 返回网关产生的错误，响应中带 request_id 便于与日志关联
+// 消息通道上的 JSON-RPC 消息得到 JSON-RPC 错误，会话存在时与后端的响应一样经 SSE 流下发，message 端点返回 202；
+// 其他请求得到 {"error": {...}} 结构的 JSON
+func writeError(w http.ResponseWriter, r *http.Request, kind errorKind, message string, data map[string]any) {
+	reqID, _ := r.Context().Value(requestIDKey).(string)
+	if reqID == "" {
+		reqID = requestID(r)
+		w.Header().Set("X-Request-Id", reqID)
+	}
+	// MCP 客户端可能运行在浏览器中，错误响应同样需要 CORS 头部才能被读取；管理接口不需要
+	if !strings.HasPrefix(r.URL.Path, "/admin/") {
+		setCORSHeaders(w.Header())
+	}
+
+	if msg, session := requestMessage(r); msg != nil {
+		errData := map[string]any{"type": kind.Type, "request_id": reqID}
+		for k, v := range data {
+			errData[k] = v
+		}
+		if !msg.isRequest() {
+			session = nil // 通知没有 id，不向 SSE 流发送响应
+		}
+		replyJSONRPC(w, session, kind.Status, jsonrpcMessage{
+			JSONRPC: mcp.JSONRPC_VERSION,
+			ID:      msg.ID,
+			Error:   &jsonrpcError{Code: kind.Code, Message: message, Data: errData},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(kind.Status)
+	json.NewEncoder(w).Encode(errorBody{Error: errorDetail{
+		Type:      kind.Type,
+		Code:      kind.Code,
+		Message:   message,
+		RequestID: reqID,
+		Data:      data,
+	}})
+}
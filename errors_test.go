@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteErrorJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	rec := httptest.NewRecorder()
+	writeError(rec, r, kindForbidden, "admin token required", map[string]any{"route": "/search"})
+
+	var body errorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusForbidden || body.Error.Type != "forbidden" || body.Error.Code != -32003 ||
+		body.Error.Message != "admin token required" || body.Error.Data["route"] != "/search" {
+		t.Errorf("%d %+v", rec.Code, body)
+	}
+	// 没有上游中间件分配的 request id 时生成一个并写入响应头
+	if id := rec.Header().Get("X-Request-Id"); id == "" || body.Error.RequestID != id {
+		t.Errorf("request id %q, header %q", body.Error.RequestID, id)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Error("admin error carries CORS headers")
+	}
+}
+
+func TestWriteErrorJSONRPC(t *testing.T) {
+	post := func(body, query string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/message?"+query, strings.NewReader(body))
+		ctx := context.WithValue(r.Context(), prefixKey, "/search")
+		return r.WithContext(context.WithValue(ctx, requestIDKey, "req-1"))
+	}
+	decode := func(t *testing.T, data string) jsonrpcMessage {
+		t.Helper()
+		var msg jsonrpcMessage
+		if err := json.Unmarshal([]byte(data), &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	// 没有会话时错误直接作为响应体返回
+	rec := httptest.NewRecorder()
+	writeError(rec, post(`{"jsonrpc":"2.0","id":"a","method":"tools/call"}`, ""), kindRateLimited, "rate limited", map[string]any{"retry_after": 2})
+	msg := decode(t, rec.Body.String())
+	if rec.Code != http.StatusTooManyRequests || string(msg.ID) != `"a"` || msg.Error == nil || msg.Error.Code != -32029 {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if data, _ := msg.Error.Data.(map[string]any); data["type"] != "rate_limited" || data["request_id"] != "req-1" || data["retry_after"] != float64(2) {
+		t.Errorf("error data = %v", msg.Error.Data)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Error("message error without CORS headers")
+	}
+
+	// 会话存在时错误与后端响应一样经 SSE 流下发
+	sseTestConfig(t)
+	s := newSession("/search", "", httptest.NewRequest(http.MethodGet, "/sse", nil))
+	s.register("up-1", "http://upstream/message?sessionId=up-1")
+	t.Cleanup(s.close)
+	rec = httptest.NewRecorder()
+	writeError(rec, post(`{"jsonrpc":"2.0","id":5,"method":"tools/call"}`, "sessionId="+s.ID), kindUnavailable, "draining", nil)
+	if rec.Code != http.StatusAccepted || len(s.replay) != 1 {
+		t.Fatalf("%d, %d events published", rec.Code, len(s.replay))
+	}
+	event := string(s.replay[0].data)
+	if !strings.Contains(event, `"id":5`) || !strings.Contains(event, `"code":-32011`) {
+		t.Errorf("published event %q", event)
+	}
+
+	// 通知没有 id，错误不进入 SSE 流
+	rec = httptest.NewRecorder()
+	writeError(rec, post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`, "sessionId="+s.ID), kindUnavailable, "draining", nil)
+	if msg := decode(t, rec.Body.String()); rec.Code != http.StatusServiceUnavailable || string(msg.ID) != "null" || len(s.replay) != 1 {
+		t.Errorf("notification: %d %s", rec.Code, rec.Body)
+	}
+}
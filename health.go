@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// 健康检查建立连接的超时
+const healthCheckTimeout = 2 * time.Second
+
+// runHealthChecks 定期探测所有已注册的后端，结果写入 upstream_up 指标
+// 每轮结束后重新读取配置中的间隔，配置重载后生效
+func runHealthChecks() {
+	for {
+		time.Sleep(config().HealthCheck.Interval)
+
+		for prefix, target := range getRoutes() {
+			if err := checkUpstream(target); err != nil {
+				logger.Warn("upstream health check failed", "route", prefix, "upstream", target, "error", err)
+				upstreamUp.WithLabelValues(prefix).Set(0)
+			} else {
+				upstreamUp.WithLabelValues(prefix).Set(1)
+			}
+		}
+	}
+}
+
+// checkUpstream 检查后端是否可用：熔断中的后端直接视为不健康，不再探测；否则只建立 TCP 连接，
+// 不请求 SSE 地址，避免每次探测都在后端（或 stdio 路由的子进程）上创建 MCP 会话
+func checkUpstream(target string) error {
+	if state, until := circuitStatus(target); state == circuitOpen {
+		return fmt.Errorf("circuit open until %s", until.Format(time.RFC3339))
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", addr, healthCheckTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// jsonrpcMessage 是 JSON-RPC 2.0 消息的通用信封，请求、通知与响应共用
+type jsonrpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// toolCallParams 是 tools/call 请求的参数
+type toolCallParams struct {
+	Name      string          `json:"name"`
+	Arguments map[string]any  `json:"arguments,omitempty"`
+	Meta      json.RawMessage `json:"_meta,omitempty"`
+}
+
+// toolCallResult 只解析 tools/call 结果中网关关心的字段
+type toolCallResult struct {
+	IsError bool `json:"isError,omitempty"`
+}
+
+// parseJSONRPC 解析单条 JSON-RPC 消息，批量消息和非法 JSON 返回 false
+func parseJSONRPC(data []byte) (*jsonrpcMessage, bool) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '{' {
+		return nil, false
+	}
+	var msg jsonrpcMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, false
+	}
+	return &msg, true
+}
+
+// isRequest 判断消息是否为需要响应的请求
+func (m *jsonrpcMessage) isRequest() bool {
+	return m.Method != "" && len(m.ID) > 0 && string(m.ID) != "null"
+}
+
+// isResponse 判断消息是否为响应（成功或失败）
+func (m *jsonrpcMessage) isResponse() bool {
+	return m.Method == "" && len(m.ID) > 0 && (m.Result != nil || m.Error != nil)
+}
+
+// toolCall 解析 tools/call 请求的参数，其他方法返回 nil
+func (m *jsonrpcMessage) toolCall() *toolCallParams {
+	if m.Method != "tools/call" || len(m.Params) == 0 {
+		return nil
+	}
+	var params toolCallParams
+	if err := json.Unmarshal(m.Params, &params); err != nil {
+		return nil
+	}
+	return &params
+}
+
+// isToolError 判断响应是否表示工具调用失败（协议错误或 isError 结果）
+func (m *jsonrpcMessage) isToolError() bool {
+	if m.Error != nil {
+		return true
+	}
+	var result toolCallResult
+	if err := json.Unmarshal(m.Result, &result); err != nil {
+		return false
+	}
+	return result.IsError
+}
+
+// idKey 将 JSON-RPC id 规范化为可作为 map 键的字符串
+func idKey(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// replyJSONRPC 在消息通道上返回网关代替后端产生的 JSON-RPC 响应
+func replyJSONRPC(w http.ResponseWriter, session *mcpSession, status int, resp jsonrpcMessage) {
+	if len(resp.ID) == 0 {
+		resp.ID = json.RawMessage("null")
+	}
+	if session != nil {
+		session.send(resp)
+		status = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
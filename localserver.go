@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// localServer 是网关自己在本地回环地址上提供的后端，如 stdio 桥接、磁带回放与模拟服务，路由目标为其 URL
+type localServer interface {
+	URL() string
+	Close() error
+}
+
+// startLocalServer 为需要网关自己提供后端的路由启动本地服务，普通 SSE 路由返回 nil
+func startLocalServer(route *RouteConfig) (localServer, error) {
+	switch route.Transport {
+	case "stdio":
+		return startStdioBridge(route.Name, *route.Stdio)
+	case "replay":
+		return startReplayServer(route.Name, *route.Replay)
+	case "mock":
+		return startMockServer(route.Name, *route.Mock)
+	}
+	return nil, nil
+}
+
+// localHandler 应答一条客户端请求，返回 nil 时不下发响应；同一会话的请求可能并发调用
+type localHandler func(msg *jsonrpcMessage) *jsonrpcMessage
+
+// localMCPServer 是网关自己实现的 MCP SSE 服务，每个 SSE 会话使用 newHandler 创建的处理函数，
+// 处理函数可以在闭包中保存会话状态
+type localMCPServer struct {
+	name       string
+	server     *http.Server
+	addr       string
+	newHandler func() localHandler
+
+	mu       sync.Mutex
+	sessions map[string]*localSession
+}
+
+// localSession 对应一个 SSE 连接，out 中的消息依次写入 SSE 流
+type localSession struct {
+	handle localHandler
+	out    chan []byte
+	done   chan struct{}
+}
+
+// startLocalMCPServer 在 127.0.0.1 的随机端口上启动服务
+func startLocalMCPServer(name string, newHandler func() localHandler) (*localMCPServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &localMCPServer{
+		name:       name,
+		addr:       ln.Addr().String(),
+		newHandler: newHandler,
+		sessions:   map[string]*localSession{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", m.handleSSE)
+	mux.HandleFunc("/message", m.handleMessage)
+	m.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := m.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("local server stopped", "server", name, "error", err)
+		}
+	}()
+	return m, nil
+}
+
+// URL 返回服务的 SSE 地址，作为路由目标
+func (m *localMCPServer) URL() string {
+	return "http://" + m.addr + "/sse"
+}
+
+// Close 关闭服务及所有 SSE 连接
+func (m *localMCPServer) Close() error {
+	return m.server.Close()
+}
+
+func (m *localMCPServer) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, kindInternal, "streaming unsupported", nil)
+		return
+	}
+
+	sessionID := randomID()
+	session := &localSession{handle: m.newHandler(), out: make(chan []byte, 16), done: make(chan struct{})}
+	m.mu.Lock()
+	m.sessions[sessionID] = session
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.sessions, sessionID)
+		m.mu.Unlock()
+		close(session.done)
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "event: endpoint\ndata: http://%s/message?sessionId=%s\n\n", m.addr, sessionID)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-session.out:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+func (m *localMCPServer) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	m.mu.Lock()
+	session := m.sessions[r.URL.Query().Get("sessionId")]
+	m.mu.Unlock()
+	if session == nil {
+		writeError(w, r, kindNotFound, "invalid session ID", map[string]any{"session": r.URL.Query().Get("sessionId")})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageBodySize))
+	if err != nil {
+		writeError(w, r, kindBadRequest, "failed to read request body", nil)
+		return
+	}
+	msg, ok := parseJSONRPC(body)
+	if !ok {
+		writeError(w, r, kindParseError, "parse error: request body is not valid JSON-RPC", nil)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	// 通知不需要响应
+	if !msg.isRequest() {
+		return
+	}
+	go func() {
+		resp := session.handle(msg)
+		if resp == nil {
+			return
+		}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return
+		}
+		select {
+		case session.out <- data:
+		case <-session.done:
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const loggerKey contextKey = "logger"
+const requestIDKey contextKey = "requestID"
+
+// logger 是网关的全局结构化日志，main 中根据配置重新初始化
+var logger = slog.Default()
+
+// logLevel 是全局日志级别，配置重载时直接修改
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel 解析 debug/info/warn/error
+func parseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return lvl, fmt.Errorf("invalid level %q", level)
+	}
+	return lvl, nil
+}
+
+// newLogger 创建结构化日志，format 为 json 或 logfmt
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "logfmt", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid format %q, expected json or logfmt", format)
+	}
+}
+
+// withLogger 将日志存入上下文
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// loggerFrom 取出上下文中带请求字段的日志，不存在时返回全局日志
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return logger
+}
+
+// requestID 复用客户端传入的 X-Request-Id，否则生成新的
+func requestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" {
+		return id
+	}
+	return randomID()[:16]
+}
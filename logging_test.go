@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelWarn)
+	l, err := newLogger(&buf, "json", level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hidden")
+	l.Warn("upstream unhealthy", "route", "/search")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("json output %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "upstream unhealthy" || entry["level"] != "WARN" || entry["route"] != "/search" {
+		t.Errorf("entry = %v", entry)
+	}
+
+	// 修改级别后已创建的日志立即生效
+	buf.Reset()
+	level.Set(slog.LevelDebug)
+	l.Debug("session registered", "session", "s1")
+	if !strings.Contains(buf.String(), `"level":"DEBUG"`) {
+		t.Errorf("debug output after level change = %q", buf.String())
+	}
+
+	buf.Reset()
+	l, err = newLogger(&buf, "logfmt", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("session registered", "session", "s1")
+	if got := buf.String(); !strings.Contains(got, "level=INFO") || !strings.Contains(got, `msg="session registered" session=s1`) {
+		t.Errorf("logfmt output = %q", got)
+	}
+
+	if _, err := newLogger(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("newLogger accepted format xml")
+	}
+	if lvl, err := parseLogLevel("warn"); err != nil || lvl != slog.LevelWarn {
+		t.Errorf("parseLogLevel(warn) = %v, %v", lvl, err)
+	}
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("parseLogLevel accepted verbose")
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	if loggerFrom(context.Background()) != logger {
+		t.Error("context without logger should use the global logger")
+	}
+	var buf bytes.Buffer
+	l, _ := newLogger(&buf, "json", slog.LevelInfo)
+	ctx := withLogger(context.Background(), l.With("request_id", "r1"))
+	loggerFrom(ctx).Info("proxy request")
+	if !strings.Contains(buf.String(), `"request_id":"r1"`) {
+		t.Errorf("request logger output = %q", buf.String())
+	}
+
+	// 复用客户端传入的请求 ID，否则生成新的
+	r := httptest.NewRequest("GET", "/sse", nil)
+	r.Header.Set("X-Request-Id", "client-id")
+	if got := requestID(r); got != "client-id" {
+		t.Errorf("requestID = %q, want client-id", got)
+	}
+	r.Header.Del("X-Request-Id")
+	if a, b := requestID(r), requestID(r); len(a) != 16 || a == b {
+		t.Errorf("generated request ids %q, %q", a, b)
+	}
+}
@@ -3,14 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"slices"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // 添加一个自定义的上下文键类型
@@ -26,6 +34,10 @@ var (
 	routeMapLock  = sync.RWMutex{}
 	proxyMap      = map[string]http.Handler{}
 	serverInfoMap = map[string]*ServerInfo{}
+	toolSchemas   = map[string]map[string]jsonSchema{} // 各路由工具的 inputSchema，见 catalogTools
+	localServers  = map[string]localServer{}
+	routeWeights  = map[string]map[string]int{} // 具名路由各版本的权重，见 canaryRequest
+	routeMetadata = map[string]*RouteMetadata{} // 注册请求中的描述信息，静态路由的描述信息在配置中
 )
 
 func getEnv(key, fallback string) string {
@@ -36,52 +48,162 @@ func getEnv(key, fallback string) string {
 }
 
 func main() {
+	flag.StringVar(&configPath, "config", getEnv("MCP_GATEWAY_CONFIG", ""), "path to the gateway config file (YAML or JSON)")
+	flag.Parse()
+
+	// 加载并校验配置
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(1)
+	}
+
+	// 初始化结构化日志
+	lvl, _ := parseLogLevel(cfg.Log.Level)
+	logLevel.Set(lvl)
+	logger, _ = newLogger(os.Stderr, cfg.Log.Format, logLevel)
+	slog.SetDefault(logger)
+
+	// 初始化链路追踪
+	shutdownTracing, err := initTracing(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to initialize tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
+	audit, err := newAuditLogger(cfg.Audit)
+	if err != nil {
+		logger.Error("failed to open audit log", "path", cfg.Audit.File, "error", err)
+		os.Exit(1)
+	}
+	auditor.Store(audit)
+
+	store, err := newSessionStore(cfg.Sessions)
+	if err != nil {
+		logger.Error("failed to open session store", "path", cfg.Sessions.Store, "error", err)
+		os.Exit(1)
+	}
+	sessionStore.Store(store)
+
+	cache, err := newResultCache(cfg.Cache)
+	if err != nil {
+		logger.Error("failed to open result cache", "dir", cfg.Cache.Dir, "error", err)
+		os.Exit(1)
+	}
+	toolCache.Store(cache)
+
+	reportRateLimits(cfg)
+
+	if path := cfg.Quotas.Store; path != "" {
+		if err := usage.load(path); err != nil {
+			logger.Error("failed to load usage", "path", path, "error", err)
+			os.Exit(1)
+		}
+		go persistUsage(path)
+	}
+
+	// 加载配置中的静态路由
+	if err := applyRoutes(&Config{}, cfg); err != nil {
+		logger.Error("failed to add static routes", "error", err)
+		os.Exit(1)
+	}
+
+	// 监听配置文件变化与 SIGHUP
+	go watchConfig()
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/overview", Overview)
 	mux.HandleFunc("/register", Register)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/admin/reload", adminMiddleware(http.HandlerFunc(AdminReload)))
+	mux.Handle("/admin/routes/drain", adminMiddleware(http.HandlerFunc(AdminRouteDrain)))
+	mux.Handle("/admin/routes/weights", adminMiddleware(http.HandlerFunc(AdminRouteWeights)))
+	mux.Handle("/admin/sessions", adminMiddleware(http.HandlerFunc(AdminSessions)))
+	mux.HandleFunc("/admin/dashboard", AdminDashboard)
+	mux.Handle("/admin/usage", adminMiddleware(http.HandlerFunc(AdminUsage)))
+	mux.Handle("/admin/cache", adminMiddleware(http.HandlerFunc(AdminCache)))
+	mux.Handle("/admin/shadow", adminMiddleware(http.HandlerFunc(AdminShadow)))
 
 	// 动态路由处理器
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// 提取请求路径中的前缀
-		path := r.URL.Path
-		var prefix string
-		for p := range getRoutes() {
-			if len(p) > 0 && p != "/" && (path == p || path+"/" == p || path[:len(p)+1] == p+"/") {
-				prefix = p
-				break
-			}
+	mux.HandleFunc("/", serveRoute)
+
+	// 后端健康检查
+	go runHealthChecks()
+
+	// 启动所有监听器，任意一个异常退出则进程退出
+	errCh := make(chan error, len(cfg.Listeners))
+	var servers []*http.Server
+	for _, listener := range cfg.Listeners {
+		server := &http.Server{
+			Addr:         listener.Addr,
+			Handler:      mountMiddleware(mux),
+			ReadTimeout:  5 * time.Minute,
+			WriteTimeout: 0, // SSE 需要无限写入超时
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 		}
+		servers = append(servers, server)
+
+		go func(listener ListenerConfig) {
+			logger.Info("gateway listening", "addr", listener.Addr, "tls", listener.TLSCert != "")
+			if listener.TLSCert != "" {
+				errCh <- server.ListenAndServeTLS(listener.TLSCert, listener.TLSKey)
+			} else {
+				errCh <- server.ListenAndServe()
+			}
+		}(listener)
+	}
 
-		if prefix == "" {
-			http.NotFound(w, r)
-			return
-		}
+	// 收到 SIGINT / SIGTERM 时优雅关闭
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errCh:
+		logger.Error("server stopped", "error", err)
+		os.Exit(1)
+	case sig := <-stop:
+		logger.Info("received signal", "signal", sig.String())
+		gracefulShutdown(servers, config().Shutdown.Timeout)
+	}
+}
 
-		// 获取或创建代理
-		handler := getOrCreateProxy(prefix)
-		if handler == nil {
-			http.Error(w, "路由目标无效", http.StatusBadGateway)
-			return
+// serveRoute 按请求路径的前缀将请求交给对应路由的代理
+func serveRoute(w http.ResponseWriter, r *http.Request) {
+	// 有多个版本的路由按权重选择版本
+	r = canaryRequest(r)
+
+	// 提取请求路径中的前缀
+	path := r.URL.Path
+	var prefix string
+	for p := range getRoutes() {
+		if len(p) > 0 && p != "/" && (path == p || path+"/" == p || strings.HasPrefix(path, p+"/")) {
+			prefix = p
+			break
 		}
+	}
 
-		// 调用处理器
-		handler.ServeHTTP(w, r)
-	})
+	if prefix == "" {
+		writeError(w, r, kindUnknownServer, "no MCP server is registered at "+path, nil)
+		return
+	}
 
-	port := getEnv("MCP_GATEWAY_PORT", "3121")
+	// 各版本只能经名称访问，否则客户端可以绕过权重直接选择版本
+	if name := routeName(prefix); name != prefix && !canaryRouted(r) {
+		writeError(w, r, kindForbidden, "versions of "+name+" are only reachable through "+name, map[string]any{"route": name})
+		return
+	}
 
-	// 设置服务器
-	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      mux,
-		ReadTimeout:  5 * time.Minute,
-		WriteTimeout: 0, // SSE 需要无限写入超时
+	// 获取或创建代理
+	handler := getOrCreateProxy(prefix)
+	if handler == nil {
+		writeError(w, r, kindUpstreamUnavailable, "route target is invalid", map[string]any{"route": prefix})
+		return
 	}
 
-	// 启动服务器
-	log.Printf("反向代理服务器启动在 :%s", port)
-	log.Fatal(server.ListenAndServe())
+	// 调用处理器
+	handler.ServeHTTP(w, r)
 }
 
 // 获取当前路由映射的安全副本
@@ -119,7 +241,7 @@ func getOrCreateProxy(prefix string) http.Handler {
 
 		targetURL, err := url.Parse(target)
 		if err != nil {
-			log.Printf("解析目标 URL %s 失败: %v", target, err)
+			logger.Error("invalid route target", "route", prefix, "target", target, "error", err)
 			return nil
 		}
 
@@ -129,11 +251,14 @@ func getOrCreateProxy(prefix string) http.Handler {
 		proxy := createReverseProxy(targetURL)
 
 		// 创建中间件来记录前缀
-		handler := prefixMiddleware(prefix)(http.StripPrefix(prefix, corsMiddleware(proxy)))
+		handler := writeTimeoutMiddleware(metricsMiddleware(prefix)(otelhttp.NewHandler(
+			prefixMiddleware(prefix)(http.StripPrefix(prefix, corsMiddleware(drainMiddleware(authMiddleware(streamMiddleware(messageMiddleware(proxy))))))),
+			"proxy "+prefix,
+		)))
 
 		// 保存到代理映射
 		proxyMap[prefix] = handler
-		log.Printf("动态添加路由 %s/* 将转发到 %s", prefix, targetURL.String())
+		logger.Info("route proxy created", "route", prefix, "upstream", targetURL.String())
 
 		return handler
 	}
@@ -149,6 +274,14 @@ func prefixMiddleware(prefix string) func(http.Handler) http.Handler {
 			ctx := context.WithValue(r.Context(), prefixKey, prefix)
 			// 将源URL存储在请求上下文中
 			ctx = context.WithValue(ctx, sourceURLKey, r.URL.String())
+			// 记录客户端访问网关的公开地址，用于改写 endpoint
+			ctx = context.WithValue(ctx, publicBaseKey, publicBaseURL(r))
+			// 为请求分配 request id，并记录到日志字段与上游请求头中
+			reqID := requestID(r)
+			r.Header.Set("X-Request-Id", reqID)
+			w.Header().Set("X-Request-Id", reqID)
+			ctx = context.WithValue(ctx, requestIDKey, reqID)
+			ctx = withLogger(ctx, logger.With("route", prefix, "request_id", reqID))
 			// 使用新的上下文创建新的请求
 			r = r.WithContext(ctx)
 			// 继续处理请求
@@ -160,29 +293,87 @@ func prefixMiddleware(prefix string) func(http.Handler) http.Handler {
 func Register(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeError(w, r, kindBadRequest, "failed to read request body", nil)
 		return
 	}
 	type RegisterReq struct {
 		ServerName string `json:"server_name"`
 		ServerURL  string `json:"server_url"`
+		Version    string `json:"version"` // 可选，带版本的后端注册为 /server_name@version，按权重分配新会话
+		Weight     *int   `json:"weight"`  // 可选，版本的权重，需要携带 admin.token
+
+		// 以下为可选的描述信息，在 /overview 中展示
+		Tags        []string         `json:"tags"`
+		Description string           `json:"description"`
+		Owner       string           `json:"owner"`
+		Contact     string           `json:"contact"`
+		Transport   string           `json:"transport"` // 网关只能代理 SSE 后端，留空或为 sse
+		Auth        *AuthRequirement `json:"auth"`
 	}
 
 	var req RegisterReq
 	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+		writeError(w, r, kindBadRequest, "invalid request body: "+err.Error(), nil)
+		return
+	}
+	if req.Version != "" && (!versionPattern.MatchString(req.Version) || req.Version == defaultVersion) {
+		writeError(w, r, kindBadRequest, fmt.Sprintf("invalid version %q", req.Version), nil)
+		return
+	}
+	if req.Weight != nil && (req.Version == "" || *req.Weight < 0) {
+		writeError(w, r, kindBadRequest, "weight requires a version and must not be negative", nil)
+		return
+	}
+	if strings.Contains(req.ServerName, "@") {
+		writeError(w, r, kindBadRequest, "server_name must not contain '@', use version instead", nil)
+		return
+	}
+	// /register 不需要认证，任何人都能注册版本；只有携带 admin.token 的注册可以直接分配流量
+	if req.Weight != nil && !adminTokenValid(r) {
+		loggerFrom(r.Context()).Warn("ignoring weight of unauthenticated registration", "server_name", req.ServerName, "version", req.Version, "weight", *req.Weight)
+		req.Weight = nil
+	}
+	if req.Transport != "" && req.Transport != "sse" {
+		writeError(w, r, kindBadRequest, fmt.Sprintf("unsupported transport %q, only sse servers can be registered", req.Transport), nil)
+		return
+	}
+	if slices.ContainsFunc(req.Tags, func(tag string) bool { return strings.TrimSpace(tag) == "" }) {
+		writeError(w, r, kindBadRequest, "tags must not be empty", nil)
 		return
 	}
 
-	fmt.Printf("Register request: %+v\n", req)
+	logger.Info("register request", "server_name", req.ServerName, "server_url", req.ServerURL, "version", req.Version)
 
 	// 安全地更新路由映射
+	name := "/" + req.ServerName
+	prefix := name
+	if req.Version != "" {
+		prefix = versionedPrefix(name, req.Version)
+	}
 	routeMapLock.Lock()
-	routeMap["/"+req.ServerName] = req.ServerURL
-	// 删除现有的代理缓存，强制重新创建
-	delete(proxyMap, req.ServerName)
+	if routeMap[prefix] != req.ServerURL {
+		routeMap[prefix] = req.ServerURL
+		// 目标变化时删除现有的代理缓存，强制重新创建
+		delete(proxyMap, prefix)
+		delete(serverInfoMap, prefix)
+		delete(toolSchemas, prefix)
+	}
+	if req.Version != "" {
+		registerVersion(name, req.Version, req.Weight)
+	}
+	routeMetadata[prefix] = &RouteMetadata{
+		Version:     req.Version,
+		Tags:        req.Tags,
+		Description: req.Description,
+		Owner:       req.Owner,
+		Contact:     req.Contact,
+		Transport:   "sse",
+		Auth:        req.Auth,
+	}
 	routeMapLock.Unlock()
 
+	registrationsTotal.WithLabelValues(prefix).Inc()
+
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Register request received"))
 }
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus 指标，均以路由前缀作为 route 标签
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_requests_total",
+		Help: "Proxied HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	proxyErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_proxy_errors_total",
+		Help: "Requests that failed to reach the upstream and were answered with 502.",
+	}, []string{"route"})
+
+	sseConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_gateway_sse_connections",
+		Help: "Currently open SSE streams.",
+	}, []string{"route"})
+
+	toolCallsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_tool_calls_total",
+		Help: "Completed tools/call requests by route, tool and outcome.",
+	}, []string{"route", "tool", "status"})
+
+	toolCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mcp_gateway_tool_call_duration_seconds",
+		Help:    "Latency between a tools/call request and its SSE-delivered result.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"route", "tool"})
+
+	registrationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_registrations_total",
+		Help: "Successful /register calls.",
+	}, []string{"route"})
+
+	upstreamUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_gateway_upstream_up",
+		Help: "Result of the last upstream health check (1 healthy, 0 unhealthy).",
+	}, []string{"route"})
+)
+
+// observeToolCall 记录一次完成的 tools/call
+func observeToolCall(s *mcpSession, call *pendingCall, resp *jsonrpcMessage) {
+	if s == nil || call == nil || call.Method != "tools/call" {
+		return
+	}
+	status := "ok"
+	if resp.isToolError() {
+		status = "error"
+	}
+	toolCallsTotal.WithLabelValues(s.Prefix, call.Tool, status).Inc()
+	toolCallDuration.WithLabelValues(s.Prefix, call.Tool).Observe(time.Since(call.Started).Seconds())
+}
+
+// statusRecorder 记录响应状态码，同时保留 Flush 能力以支持 SSE
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// 指标中间件：按路由统计请求数与状态码
+func metricsMiddleware(prefix string) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &statusRecorder{ResponseWriter: w}
+			// SSE 流被客户端中断时 ReverseProxy 会以 http.ErrAbortHandler panic，需在 defer 中计数
+			defer func() {
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+				requestsTotal.WithLabelValues(prefix, r.Method, strconv.Itoa(rec.status)).Inc()
+			}()
+			handler.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metricValue 读取计数器的当前值或直方图的样本数
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+	var out dto.Metric
+	if err := m.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if h := out.GetHistogram(); h != nil {
+		return float64(h.GetSampleCount())
+	}
+	return out.GetCounter().GetValue()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	const route = "/metrics-test"
+	handler := metricsMiddleware(route)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/abort":
+			// SSE 流被客户端中断时 ReverseProxy 以 ErrAbortHandler panic，已写出的响应按 200 计数
+			w.Write([]byte("event: endpoint\n\n"))
+			panic(http.ErrAbortHandler)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+
+	before := map[string]float64{}
+	for _, key := range []string{"GET 200", "POST 200", "GET 404"} {
+		method, code, _ := strings.Cut(key, " ")
+		before[key] = metricValue(t, requestsTotal.WithLabelValues(route, method, code))
+	}
+
+	serve := func(method, path string) {
+		defer func() {
+			if p := recover(); p != nil && p != http.ErrAbortHandler {
+				panic(p)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+	}
+	serve(http.MethodGet, "/sse")
+	serve(http.MethodPost, "/message")
+	serve(http.MethodPost, "/message")
+	serve(http.MethodGet, "/missing")
+	serve(http.MethodGet, "/abort")
+
+	tests := []struct {
+		method, code string
+		want         float64
+	}{
+		{"GET", "200", 2},
+		{"POST", "200", 2},
+		{"GET", "404", 1},
+	}
+	for _, tt := range tests {
+		got := metricValue(t, requestsTotal.WithLabelValues(route, tt.method, tt.code)) - before[tt.method+" "+tt.code]
+		if got != tt.want {
+			t.Errorf("requests_total{method=%s,code=%s} = %g, want %g", tt.method, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestObserveToolCall(t *testing.T) {
+	s := &mcpSession{ID: "s1", Prefix: "/metrics-test"}
+	ok := toolCallsTotal.WithLabelValues(s.Prefix, "web_search", "ok")
+	failed := toolCallsTotal.WithLabelValues(s.Prefix, "web_search", "error")
+	duration := toolCallDuration.WithLabelValues(s.Prefix, "web_search").(prometheus.Metric)
+	okBefore, failedBefore, durationBefore := metricValue(t, ok), metricValue(t, failed), metricValue(t, duration)
+
+	call := func(tool string) *pendingCall {
+		return &pendingCall{Method: "tools/call", Tool: tool, Started: time.Now()}
+	}
+	observeToolCall(s, call("web_search"), &jsonrpcMessage{Result: json.RawMessage(`{"content":[]}`)})
+	observeToolCall(s, call("web_search"), &jsonrpcMessage{Result: json.RawMessage(`{"content":[],"isError":true}`)})
+	observeToolCall(s, call("web_search"), &jsonrpcMessage{Error: &jsonrpcError{Code: -32603, Message: "boom"}})
+	// 其他方法不计入工具调用
+	observeToolCall(s, &pendingCall{Method: "tools/list", Started: time.Now()}, &jsonrpcMessage{})
+
+	if got := metricValue(t, ok) - okBefore; got != 1 {
+		t.Errorf("ok calls = %g, want 1", got)
+	}
+	if got := metricValue(t, failed) - failedBefore; got != 2 {
+		t.Errorf("error calls = %g, want 2", got)
+	}
+	if got := metricValue(t, duration) - durationBefore; got != 3 {
+		t.Errorf("tool call duration has %g samples, want 3", got)
+	}
+}
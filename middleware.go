@@ -1,16 +1,33 @@
 package main
 
-import "net/http"
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// setCORSHeaders 设置网关响应统一使用的 CORS 头部
+func setCORSHeaders(h http.Header) {
+	h.Set("Access-Control-Allow-Origin", "*") // 或者指定域名
+	h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID, Mcp-Session-Id")
+	h.Set("Access-Control-Allow-Credentials", "true")
+}
 
 // CORS 中间件
 func corsMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// 对于 OPTIONS 请求，直接返回 CORS 头部
 		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Origin", "*") // 或者指定域名
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			setCORSHeaders(w.Header())
 			w.Header().Set("Access-Control-Max-Age", "86400") // 24小时
 			w.WriteHeader(http.StatusOK)
 			return
@@ -20,3 +37,201 @@ func corsMiddleware(handler http.Handler) http.Handler {
 		handler.ServeHTTP(w, r)
 	})
 }
+
+// deadlineWriter 在每次写出前设置写超时，客户端失联导致写阻塞时写入失败，
+// ReverseProxy 随之结束请求并关闭上游连接
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *deadlineWriter) Flush() {
+	w.rc.SetWriteDeadline(time.Now().Add(w.timeout))
+	w.rc.Flush()
+}
+
+func (w *deadlineWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// 写超时中间件：为代理响应设置逐次写入的超时，长连接的 SSE 流也能发现已断开的客户端
+func writeTimeoutMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := config().SSE.WriteTimeout
+		if timeout <= 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		rc := http.NewResponseController(w)
+		// 连接可能被下一个请求复用，结束后清除写超时
+		defer rc.SetWriteDeadline(time.Time{})
+		handler.ServeHTTP(&deadlineWriter{ResponseWriter: w, rc: rc, timeout: timeout}, r)
+	})
+}
+
+// 单条 JSON-RPC 消息的最大读取长度
+const maxMessageBodySize = 4 << 20
+
+// MCP 消息中间件：解析客户端 POST 到 message 端点的 JSON-RPC 请求并登记到会话
+func messageMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID := r.URL.Query().Get("sessionId")
+		if r.Method != http.MethodPost || sessionID == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		prefix, _ := r.Context().Value(prefixKey).(string)
+
+		// 会话 id 由网关分配，不在会话表中的 id 后端同样无法识别
+		session := lookupSession(prefix, sessionID)
+		if session == nil {
+			writeError(w, r, kindNotFound, "session not found or expired, reconnect to the SSE endpoint", map[string]any{"session": sessionID})
+			return
+		}
+
+		// 客户端使用网关分配的会话 id，转发前替换为后端的 sessionId
+		r = r.Clone(r.Context())
+		query := r.URL.Query()
+		query.Set("sessionId", session.UpstreamID)
+		r.URL.RawQuery = query.Encode()
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageBodySize))
+		r.Body.Close()
+		if err != nil {
+			writeError(w, r, kindBadRequest, "failed to read request body", nil)
+			return
+		}
+		session.bytesIn.Add(int64(len(body)))
+
+		msg, ok := parseJSONRPC(body)
+		if ok && msg.Method != "" {
+			r = r.WithContext(withMessage(r.Context(), msg, session))
+		}
+		if ok {
+			session.cassette.record("client", body)
+		}
+
+		if !ok || !msg.isRequest() {
+			// 客户端取消请求时不再等待其结果，取消通知照常转发到后端
+			if ok && msg.Method == "notifications/cancelled" {
+				session.clientCancelled(msg.Params)
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		// 网关关闭期间不再接受新的工具调用
+		if msg.Method == "tools/call" && shuttingDown.Load() {
+			writeError(w, r, kindUnavailable, "gateway is shutting down", nil)
+			return
+		}
+
+		// 路由策略限制可调用的工具
+		if params := msg.toolCall(); params != nil && !config().policyFor(prefix).toolAllowed(params.Name) {
+			loggerFrom(r.Context()).Warn("tool call denied by policy", "tool", params.Name)
+			writeError(w, r, kindForbidden, fmt.Sprintf("tool %q is not allowed on this route", params.Name), map[string]any{"tool": params.Name})
+			return
+		}
+
+		// 按工具的 inputSchema 校验参数，不合法的调用不转发到后端，也不计入限流与配额
+		if params := msg.toolCall(); params != nil && config().policyFor(prefix).validatesArguments() {
+			if errs := checkArguments(prefix, params); len(errs) > 0 {
+				loggerFrom(r.Context()).Warn("invalid tool arguments", "tool", params.Name, "errors", errs)
+				invalidArgumentsTotal.WithLabelValues(prefix, params.Name).Inc()
+				writeError(w, r, kindInvalidParams, fmt.Sprintf("invalid arguments for tool %q: %s", params.Name, strings.Join(errs, "; ")),
+					map[string]any{"tool": params.Name, "errors": errs})
+				return
+			}
+		}
+
+		// 开启缓存的工具在 ttl 内直接返回相同参数的结果，命中的调用不计入限流与配额
+		if params := msg.toolCall(); params != nil {
+			// per_user 规则只缓存携带 API Key 的会话，见 cacheUser
+			if rule := config().policyFor(prefix).cacheRule(params.Name); rule != nil {
+				if user, ok := cacheUser(rule, session); ok {
+					now := time.Now()
+					if entry, ok := toolCache.Load().get(cacheKey(prefix, params.Name, params.Arguments, user), now); ok {
+						loggerFrom(r.Context()).Debug("tool result served from cache", "tool", params.Name, "age", now.Sub(entry.Stored).String())
+						cacheRequestsTotal.WithLabelValues(prefix, params.Name, "hit").Inc()
+						session.requests.Add(1)
+						session.toolCalls.Add(1)
+						resp := jsonrpcMessage{JSONRPC: mcp.JSONRPC_VERSION, ID: msg.ID, Result: entry.resultWithMeta(now)}
+						auditor.Load().record(session, &pendingCall{ID: msg.ID, Method: msg.Method, Tool: params.Name, Args: params.Arguments, Started: now}, &resp, len(entry.Result))
+						w.Header().Set(cacheHeader, "hit")
+						replyJSONRPC(w, session, http.StatusOK, resp)
+						return
+					}
+					cacheRequestsTotal.WithLabelValues(prefix, params.Name, "miss").Inc()
+					w.Header().Set(cacheHeader, "miss")
+				}
+			}
+		}
+
+		// 按路由策略限流，被拒绝的调用不转发到后端
+		if params := msg.toolCall(); params != nil {
+			if rule, wait := rateLimited(prefix, params.Name, r); rule != nil {
+				retryAfter := math.Ceil(wait.Seconds())
+				loggerFrom(r.Context()).Warn("tool call rate limited", "tool", params.Name, "rule", rule.Name, "retry_after", retryAfter)
+				rateLimitedTotal.WithLabelValues(prefix, params.Name, rule.Name).Inc()
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+				writeError(w, r, kindRateLimited, fmt.Sprintf("rate limit exceeded for tool %q, retry after %gs", params.Name, retryAfter),
+					map[string]any{"rule": rule.Name, "retry_after": retryAfter})
+				return
+			}
+		}
+
+		// 按配额记账，超出配额时按规则阻止、告警或改由降级路由处理
+		if params := msg.toolCall(); params != nil {
+			decision := usage.admit(quotaUsageKey(r, prefix, params.Name), time.Now())
+			if rule := decision.rule; rule != nil {
+				log := loggerFrom(r.Context()).With("tool", params.Name, "rule", rule.Name, "subject", decision.subject, "used", decision.used, "limit", rule.Limit)
+				quotaExceededTotal.WithLabelValues(prefix, rule.Name, rule.Action).Inc()
+				message := fmt.Sprintf("%s quota %q exhausted (%d/%d calls this %s)", rule.Per, rule.Name, decision.used, rule.Limit, rule.Period)
+				data := map[string]any{"rule": rule.Name, "used": decision.used, "limit": rule.Limit, "resets": decision.resets}
+				switch rule.Action {
+				case "warn":
+					log.Warn("quota exceeded")
+					session.notify("notifications/message", map[string]any{"level": "warning", "logger": "mcp-gateway", "data": message})
+				case "downgrade":
+					log.Warn("quota exceeded, downgrading tool call", "downgrade_to", rule.DowngradeTo)
+					serveDowngraded(w, r, session, msg, params, rule.DowngradeTo, message, data)
+					return
+				default:
+					log.Warn("tool call blocked by quota")
+					writeError(w, r, kindQuotaExceeded, message, data)
+					return
+				}
+			}
+		}
+
+		if msg.Method == "initialize" {
+			session.setInitialize(msg.Params)
+		}
+		// 后端可能在处理完成前一直不返回 POST 的响应，请求超时或被取消时结束转发
+		ctx, abort := context.WithCancelCause(r.Context())
+		defer abort(nil)
+		r = r.WithContext(ctx)
+		span := startCallSpan(ctx, prefix, msg)
+		session.trackCall(msg, span, abort)
+		body = injectTraceMeta(body, span)
+
+		// 幂等请求在后端连接失败时可以重试
+		if idempotentMethods[msg.Method] {
+			r = r.WithContext(withRetryBody(r.Context(), body))
+		}
+
+		// 恢复请求体，交给后端处理
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+
+		handler.ServeHTTP(w, r)
+	})
+}
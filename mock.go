@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// 模拟响应模板可用的函数，json 将值编码为 JSON
+var mockTemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// parseMockTemplate 解析工具的响应模板，没有 response 时返回 nil
+func parseMockTemplate(tool MockToolConfig) (*template.Template, error) {
+	if tool.Response == "" {
+		return nil, nil
+	}
+	return template.New(tool.Name).Funcs(mockTemplateFuncs).Parse(tool.Response)
+}
+
+// mockTool 是解析好响应模板的模拟工具
+type mockTool struct {
+	MockToolConfig
+	response *template.Template
+}
+
+// startMockServer 启动按配置应答的模拟 MCP 服务
+func startMockServer(name string, cfg MockConfig) (*localMCPServer, error) {
+	tools := map[string]*mockTool{}
+	list := make([]map[string]any, 0, len(cfg.Tools))
+	for _, tool := range cfg.Tools {
+		tmpl, err := parseMockTemplate(tool)
+		if err != nil {
+			return nil, fmt.Errorf("tool %q: %w", tool.Name, err)
+		}
+		tools[tool.Name] = &mockTool{MockToolConfig: tool, response: tmpl}
+
+		schema := tool.InputSchema
+		if schema == nil {
+			schema = map[string]any{"type": "object"}
+		}
+		list = append(list, map[string]any{"name": tool.Name, "description": tool.Description, "inputSchema": schema})
+	}
+	toolList, err := json.Marshal(map[string]any{"tools": list})
+	if err != nil {
+		return nil, fmt.Errorf("encode tools: %w", err)
+	}
+
+	serverInfo := mcp.Implementation{Name: cfg.ServerName, Version: cfg.ServerVersion}
+	if serverInfo.Name == "" {
+		serverInfo.Name = name
+	}
+	if serverInfo.Version == "" {
+		serverInfo.Version = "mock"
+	}
+
+	handler := func(msg *jsonrpcMessage) *jsonrpcMessage {
+		resp := &jsonrpcMessage{JSONRPC: mcp.JSONRPC_VERSION, ID: msg.ID}
+		switch msg.Method {
+		case "initialize":
+			var params struct {
+				ProtocolVersion string `json:"protocolVersion"`
+			}
+			json.Unmarshal(msg.Params, &params)
+			if params.ProtocolVersion == "" {
+				params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+			}
+			resp.Result, _ = json.Marshal(map[string]any{
+				"protocolVersion": params.ProtocolVersion,
+				"capabilities":    map[string]any{"tools": map[string]any{}},
+				"serverInfo":      serverInfo,
+				"instructions":    cfg.Instructions,
+			})
+		case "ping":
+			resp.Result = json.RawMessage("{}")
+		case "tools/list":
+			resp.Result = toolList
+		case "tools/call":
+			params := msg.toolCall()
+			if params == nil {
+				resp.Error = &jsonrpcError{Code: mcp.INVALID_PARAMS, Message: "invalid tools/call params"}
+				break
+			}
+			tool, ok := tools[params.Name]
+			if !ok {
+				resp.Error = &jsonrpcError{Code: mcp.INVALID_PARAMS, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+				break
+			}
+			if tool.Delay > 0 {
+				time.Sleep(tool.Delay)
+			}
+			resp.Result = tool.call(params.Arguments)
+		default:
+			resp.Error = &jsonrpcError{Code: mcp.METHOD_NOT_FOUND, Message: fmt.Sprintf("method %q not supported by mock server", msg.Method)}
+		}
+		return resp
+	}
+
+	logger.Info("mock server started", "server", name, "tools", len(tools))
+	return startLocalMCPServer(name, func() localHandler { return handler })
+}
+
+// call 返回工具的结果：result 原样返回，response 以调用参数渲染为文本内容，模板执行失败时返回 isError 结果
+func (t *mockTool) call(args map[string]any) json.RawMessage {
+	if t.Result != nil {
+		data, _ := json.Marshal(t.Result)
+		return data
+	}
+
+	text, isError := "", t.IsError
+	if t.response != nil {
+		if args == nil {
+			args = map[string]any{}
+		}
+		var b strings.Builder
+		if err := t.response.Execute(&b, args); err != nil {
+			text, isError = fmt.Sprintf("mock response template failed: %v", err), true
+		} else {
+			text = b.String()
+		}
+	}
+	data, _ := json.Marshal(mcp.CallToolResult{
+		Content: []mcp.Content{mcp.TextContent{Type: "text", Text: text}},
+		IsError: isError,
+	})
+	return data
+}
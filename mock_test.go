@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mockRouteYAML = `
+routes:
+  - name: translate
+    prefix: /translate
+    transport: mock
+    mock:
+      server_name: translator
+      tools:
+        - name: translate
+          input_schema: {type: object, required: [text]}
+          response: '[{{.target}}] {{.text}}'
+        - name: fail
+          response: '{{index .items 5}}'
+        - name: fixed
+          result: {content: [{type: text, text: fixed}], isError: true}
+`
+
+func TestMockRoute(t *testing.T) {
+	resetRoutes(t)
+	cfg, err := parseTestConfig(t, mockRouteYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SSE = SSEConfig{}
+	useConfig(t, cfg)
+	server, err := startMockServer("translate", *cfg.Routes[0].Mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	gw := newTestGateway(t, "/translate", server.URL())
+
+	c := dialSSE(t, gw.URL+"/translate/sse", nil)
+	c.waitEndpoint()
+	call := func(body string) *jsonrpcMessage {
+		t.Helper()
+		if code, resp := c.post(body, nil); code != http.StatusAccepted {
+			t.Fatalf("POST: %d %s", code, resp)
+		}
+		return c.nextMessage(5 * time.Second)
+	}
+
+	if msg := call(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`); !strings.Contains(string(msg.Result), `"protocolVersion":"2024-11-05"`) ||
+		!strings.Contains(string(msg.Result), `"name":"translator"`) {
+		t.Errorf("initialize = %s", msg.Result)
+	}
+	if msg := call(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`); !strings.Contains(string(msg.Result), `"inputSchema":{"required":["text"],"type":"object"}`) ||
+		!strings.Contains(string(msg.Result), `{"description":"","inputSchema":{"type":"object"},"name":"fixed"}`) {
+		t.Errorf("tools/list = %s", msg.Result)
+	}
+
+	tests := []struct {
+		name, args, want string
+	}{
+		{"translate", `{"text":"hello","target":"zh"}`, `{"content":[{"type":"text","text":"[zh] hello"}]}`},
+		{"fail", `{"items":[1]}`, `"isError":true`},
+		{"fixed", `{}`, `{"content":[{"text":"fixed","type":"text"}],"isError":true}`},
+	}
+	for i, tt := range tests {
+		msg := call(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":%q,"arguments":%s}}`, 10+i, tt.name, tt.args))
+		if !strings.Contains(string(msg.Result), tt.want) {
+			t.Errorf("%s = %s, want %s", tt.name, msg.Result, tt.want)
+		}
+	}
+
+	if msg := call(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"missing"}}`); msg.Error == nil || !strings.Contains(msg.Error.Message, `unknown tool "missing"`) {
+		t.Errorf("unknown tool = %+v", msg)
+	}
+	if msg := call(`{"jsonrpc":"2.0","id":4,"method":"resources/list"}`); msg.Error == nil || msg.Error.Code != -32601 {
+		t.Errorf("unsupported method = %+v", msg)
+	}
+}
+
+func TestMockConfigValidate(t *testing.T) {
+	tests := []struct {
+		name, tools, want string
+	}{
+		{"no tools", `[]`, "mock.tools"},
+		{"duplicate", `[{name: a}, {name: a}]`, `duplicate tool "a"`},
+		{"result and response", `[{name: a, response: x, result: {content: []}}]`, "result: not allowed together with response"},
+		{"bad template", `[{name: a, response: '{{.x'}]`, "tools[0].response"},
+		{"negative delay", `[{name: a, delay: -1s}]`, "tools[0].delay"},
+	}
+	for _, tt := range tests {
+		_, err := parseTestConfig(t, "routes:\n  - {name: m, prefix: /m, transport: mock, mock: {tools: "+tt.tools+"}}\n")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -3,20 +3,25 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"maps"
 	"net/http"
 	"net/url"
-	"os"
+	"slices"
+	"strings"
 	"time"
 
-	"github.com/daodao97/xgo/xlog"
 	_client "github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 type ServerInfo struct {
-	Type      string                `json:"type"`
-	Url       string                `json:"url"`
+	Type string `json:"type"`
+	Url  string `json:"url"`
+	*RouteMetadata
+	Weights   map[string]int        `json:"weights,omitempty"`            // 有多个版本的路由各版本的权重
+	Circuit   string                `json:"circuit"`                      // 后端熔断状态：closed、half-open、open
+	OpenUntil *time.Time            `json:"circuit_open_until,omitempty"` // 熔断中时放行下一个探测请求的时间
 	Info      *mcp.InitializeResult `json:"info,omitempty"`
 	Prompt    []mcp.PromptMessage   `json:"prompt,omitempty"`
 	Tools     []mcp.Tool            `json:"tools,omitempty"`
@@ -24,9 +29,11 @@ type ServerInfo struct {
 }
 
 func getServerInfo(serverUrl string) (*ServerInfo, error) {
+	log := logger.With("upstream", serverUrl)
+
 	client, err := _client.NewSSEMCPClient(serverUrl)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		return nil, fmt.Errorf("create client: %w", err)
 	}
 	defer client.Close()
 
@@ -35,8 +42,7 @@ func getServerInfo(serverUrl string) (*ServerInfo, error) {
 
 	// Start the client
 	if err := client.Start(ctx); err != nil {
-		xlog.Error("Failed to start client", xlog.String("serverUrl", serverUrl), xlog.Err(err))
-		return nil, err
+		return nil, fmt.Errorf("start client: %w", err)
 	}
 
 	// Initialize
@@ -49,41 +55,40 @@ func getServerInfo(serverUrl string) (*ServerInfo, error) {
 
 	result, err := client.Initialize(ctx, initRequest)
 	if err != nil {
-		xlog.Error("Failed to initialize", xlog.String("serverUrl", serverUrl), xlog.Err(err))
-		return nil, err
+		return nil, fmt.Errorf("initialize: %w", err)
 	}
 
 	// Test Ping
 	if err := client.Ping(ctx); err != nil {
-		xlog.Error("Ping failed", xlog.String("serverUrl", serverUrl), xlog.Err(err))
+		log.Warn("ping failed", "error", err)
 	}
 
 	// Test ListTools
 	toolsRequest := mcp.ListToolsRequest{}
 	toolsResult, err := client.ListTools(ctx, toolsRequest)
 	if err != nil {
-		xlog.Error("ListTools failed", xlog.String("serverUrl", serverUrl), xlog.Err(err))
+		log.Warn("list tools failed", "error", err)
 	}
 
-	xlog.Info("ListTools result", xlog.Any("result", toolsResult))
+	log.Debug("list tools result", "result", toolsResult)
 
 	// Test ListResources
 	resourcesRequest := mcp.ListResourcesRequest{}
 	resourcesResult, err := client.ListResources(ctx, resourcesRequest)
 	if err != nil {
-		xlog.Error("ListResources failed", xlog.String("serverUrl", serverUrl), xlog.Err(err))
+		log.Warn("list resources failed", "error", err)
 	}
 
-	xlog.Info("ListResources result", xlog.Any("result", resourcesResult))
+	log.Debug("list resources result", "result", resourcesResult)
 
 	// Test GetPrompt
 	promptRequest := mcp.GetPromptRequest{}
 	promptResult, err := client.GetPrompt(ctx, promptRequest)
 	if err != nil {
-		xlog.Error("GetPrompt failed", xlog.String("serverUrl", serverUrl), xlog.Err(err))
+		log.Warn("get prompt failed", "error", err)
 	}
 
-	xlog.Info("GetPrompt result", xlog.Any("result", promptResult))
+	log.Debug("get prompt result", "result", promptResult)
 
 	info := &ServerInfo{
 		Info: result,
@@ -101,44 +106,181 @@ func getServerInfo(serverUrl string) (*ServerInfo, error) {
 	return info, nil
 }
 
+// Overview 列出网关上的 MCP 服务，tag 参数只列出带有这些标签的服务（可重复，需全部匹配），
+// format=client 时返回可直接用于客户端的 mcpServers 配置
 func Overview(w http.ResponseWriter, r *http.Request) {
-	domain := os.Getenv("MCP_GATEWAY_DOMAIN")
-	if domain == "" {
-		domain = "http://localhost:3121"
-	}
-
-	_domain, err := url.Parse(domain)
-	if err != nil {
-		xlog.Error("Failed to parse domain", xlog.String("domain", domain), xlog.Err(err))
-		return
+	tags := r.URL.Query()["tag"]
+	wanted := func(prefix string) bool {
+		md := routeMetadataFor(prefix)
+		for _, tag := range tags {
+			if !slices.Contains(md.Tags, tag) {
+				return false
+			}
+		}
+		return true
 	}
 
-	for prefix, serveUrl := range routeMap {
-		if _, ok := serverInfoMap[prefix]; ok {
+	// 要列出的路由：缓存的服务信息，以及熔断中只有地址的路由
+	listed := map[string]*ServerInfo{}
+	for prefix, serveUrl := range getRoutes() {
+		routeMapLock.RLock()
+		_, ok := serverInfoMap[prefix]
+		routeMapLock.RUnlock()
+		if ok || !wanted(prefix) {
 			continue
 		}
-		serverInfo, err := getServerInfo(serveUrl)
+		_severUrl, err := url.Parse(serveUrl)
 		if err != nil {
-			xlog.Error("Failed to get server info", xlog.String("serverUrl", serveUrl), xlog.Err(err))
+			logger.Warn("invalid server url", "route", prefix, "upstream", serveUrl, "error", err)
 			continue
 		}
+		// 缓存中只保存网关路径，公开地址随请求计算
+		gatewayPath := (&url.URL{Path: prefix + _severUrl.Path, RawQuery: _severUrl.RawQuery}).String()
 
-		_severUrl, err := url.Parse(serveUrl)
+		// 熔断中的后端不再探测，只列出地址与熔断状态
+		if state, _ := circuitStatus(serveUrl); state == circuitOpen {
+			listed[prefix] = &ServerInfo{Type: "sse", Url: gatewayPath}
+			continue
+		}
+
+		serverInfo, err := getServerInfo(serveUrl)
 		if err != nil {
-			xlog.Error("Failed to parse server url", xlog.String("serverUrl", serveUrl), xlog.Err(err))
+			logger.Warn("failed to get server info", "route", prefix, "upstream", serveUrl, "error", err)
 			continue
 		}
 
-		_severUrl.Scheme = _domain.Scheme
-		_severUrl.Host = _domain.Host
-		_severUrl.Path = prefix + _severUrl.Path
 		serverInfo.Type = "sse"
-		serverInfo.Url = _severUrl.String()
+		serverInfo.Url = gatewayPath
+		if raw, err := json.Marshal(map[string]any{"tools": serverInfo.Tools}); err == nil {
+			catalogTools(prefix, raw)
+		}
+		routeMapLock.Lock()
 		serverInfoMap[prefix] = serverInfo
+		routeMapLock.Unlock()
+	}
+
+	base := publicBaseURL(r)
+	routes := getRoutes()
+	routeMapLock.RLock()
+	cached := maps.Clone(serverInfoMap)
+	weights := make(map[string]map[string]int, len(routeWeights))
+	for name, versions := range routeWeights {
+		weights[name] = maps.Clone(versions)
+	}
+	routeMapLock.RUnlock()
+	// routeMetadataFor 需要获取 routeMapLock，在释放锁之后再按标签过滤
+	for prefix, info := range cached {
+		if wanted(prefix) {
+			listed[prefix] = info
+		}
+	}
+	result := make(map[string]*ServerInfo, len(listed))
+	for prefix, info := range listed {
+		copied := *info
+		if u, err := url.Parse(info.Url); err == nil {
+			copied.Url = base.ResolveReference(&url.URL{Path: base.Path + u.Path, RawQuery: u.RawQuery}).String()
+		}
+		state, until := circuitStatus(routes[prefix])
+		copied.Circuit = state
+		if !until.IsZero() {
+			copied.OpenUntil = &until
+		}
+		copied.RouteMetadata = routeMetadataFor(prefix)
+		result[prefix] = &copied
+	}
+
+	// 有多个版本的路由以名称列出，客户端访问名称时由网关按权重选择版本
+	for name, versions := range weights {
+		if info, ok := result[name]; ok {
+			info.Weights = versions
+			continue
+		}
+		// 没有不带版本的后端时，以权重最高的版本的信息代表该名称
+		var top string
+		for _, version := range slices.Sorted(maps.Keys(versions)) {
+			if _, ok := result[versionedPrefix(name, version)]; ok && (top == "" || versions[version] > versions[top]) {
+				top = version
+			}
+		}
+		if top == "" {
+			continue
+		}
+		copied := *result[versionedPrefix(name, top)]
+		copied.Url = strings.Replace(copied.Url, versionedPrefix(name, top)+"/", name+"/", 1)
+		md := *copied.RouteMetadata
+		md.Version = ""
+		copied.RouteMetadata = &md
+		copied.Weights = versions
+		result[name] = &copied
 	}
 
 	// response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(serverInfoMap)
+	if r.URL.Query().Get("format") == "client" {
+		json.NewEncoder(w).Encode(clientConfig(result, weights))
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// routeMetadataFor 返回路由的描述信息：注册的后端取自注册请求，静态路由取自配置；
+// 带版本的前缀没有自己的描述信息时沿用名称的描述信息，版本以前缀中的为准。路由策略要求认证时标记需要 API Key
+func routeMetadataFor(prefix string) *RouteMetadata {
+	md, ok := declaredMetadata(prefix)
+	if name, version, versioned := strings.Cut(prefix, "@"); versioned {
+		if !ok {
+			md, _ = declaredMetadata(name)
+			md.Version = ""
+		}
+		if md.Version == "" {
+			md.Version = version
+		}
+	}
+	if md.Transport == "" {
+		md.Transport = "sse"
+	}
+	if policy := config().policyFor(prefix); policy.RequireAuth != nil && *policy.RequireAuth && (md.Auth == nil || !md.Auth.Required) {
+		md.Auth = &AuthRequirement{Required: true, Type: "api_key"}
+	}
+	return &md
+}
+
+// declaredMetadata 返回为前缀注册或配置的描述信息，注册请求会替换静态路由的后端，因此优先于配置
+func declaredMetadata(prefix string) (RouteMetadata, bool) {
+	routeMapLock.RLock()
+	registered := routeMetadata[prefix]
+	routeMapLock.RUnlock()
+	if registered != nil {
+		return *registered, true
+	}
+	if route := config().routeByPrefix(prefix); route != nil {
+		md := route.Metadata
+		md.Transport = route.Transport
+		return md, true
+	}
+	return RouteMetadata{}, false
+}
+
+// mcpServerConfig 是客户端配置文件（如 Cursor 的 mcp.json）中的一个服务
+type mcpServerConfig struct {
+	Type    string            `json:"type"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// clientConfig 生成客户端的 mcpServers 配置，有多个版本的路由只列出名称；需要认证的服务带上待填写的 Authorization 头部
+func clientConfig(servers map[string]*ServerInfo, weights map[string]map[string]int) map[string]any {
+	entries := map[string]mcpServerConfig{}
+	for prefix, info := range servers {
+		if name, _, ok := strings.Cut(prefix, "@"); ok && weights[name] != nil {
+			continue
+		}
+		server := mcpServerConfig{Type: "sse", URL: info.Url}
+		if info.RouteMetadata != nil && info.Auth != nil && info.Auth.Required {
+			server.Headers = map[string]string{"Authorization": "Bearer <api-key>"}
+		}
+		entries[strings.TrimPrefix(prefix, "/")] = server
+	}
+	return map[string]any{"mcpServers": entries}
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// cacheServerInfo 写入服务信息缓存，使 Overview 不去探测后端
+func cacheServerInfo(prefixes ...string) {
+	routeMapLock.Lock()
+	defer routeMapLock.Unlock()
+	for _, prefix := range prefixes {
+		serverInfoMap[prefix] = &ServerInfo{Type: "sse", Url: prefix + "/sse"}
+	}
+}
+
+func overview(t *testing.T, query string) map[string]json.RawMessage {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Overview(rec, httptest.NewRequest(http.MethodGet, "http://gateway.test/overview"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("overview%s: status %d: %s", query, rec.Code, rec.Body)
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("overview%s: %v", query, err)
+	}
+	return result
+}
+
+func TestOverviewTagFilter(t *testing.T) {
+	resetRoutes(t)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:1/sse","tags":["web","prod"]}`)
+	register(t, `{"server_name":"files","server_url":"http://127.0.0.1:2/sse","tags":["prod"]}`)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:3/sse","version":"v2","weight":0}`)
+	cacheServerInfo("/search", "/files", "/search@v2")
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"/search", "/files", "/search@v2"}},
+		{"?tag=prod", []string{"/search", "/files"}},
+		{"?tag=prod&tag=web", []string{"/search"}},
+		{"?tag=missing", nil},
+	}
+	for _, tt := range tests {
+		result := overview(t, tt.query)
+		if len(result) != len(tt.want) {
+			t.Errorf("overview%s: got %d servers, want %v", tt.query, len(result), tt.want)
+		}
+		for _, prefix := range tt.want {
+			if _, ok := result[prefix]; !ok {
+				t.Errorf("overview%s: missing %s", tt.query, prefix)
+			}
+		}
+	}
+
+	var info struct {
+		Version string   `json:"version"`
+		Tags    []string `json:"tags"`
+	}
+	json.Unmarshal(overview(t, "")["/search@v2"], &info)
+	if info.Version != "v2" || len(info.Tags) != 0 {
+		t.Errorf("/search@v2 metadata = %+v, want version v2 without tags", info)
+	}
+}
+
+func TestOverviewClientFormat(t *testing.T) {
+	resetRoutes(t)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:1/sse","auth":{"required":true,"type":"api_key"}}`)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:2/sse","version":"v2"}`)
+	register(t, `{"server_name":"files","server_url":"http://127.0.0.1:3/sse"}`)
+	cacheServerInfo("/search", "/search@v2", "/files")
+
+	rec := httptest.NewRecorder()
+	Overview(rec, httptest.NewRequest(http.MethodGet, "http://gateway.test/overview?format=client", nil))
+	var got struct {
+		MCPServers map[string]mcpServerConfig `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]mcpServerConfig{
+		"search": {Type: "sse", URL: "http://gateway.test/search/sse", Headers: map[string]string{"Authorization": "Bearer <api-key>"}},
+		"files":  {Type: "sse", URL: "http://gateway.test/files/sse"},
+	}
+	if len(got.MCPServers) != len(want) {
+		t.Fatalf("mcpServers = %+v, want %+v", got.MCPServers, want)
+	}
+	for name, server := range want {
+		g := got.MCPServers[name]
+		if g.Type != server.Type || g.URL != server.URL || g.Headers["Authorization"] != server.Headers["Authorization"] {
+			t.Errorf("mcpServers[%s] = %+v, want %+v", name, g, server)
+		}
+	}
+}
+
+func TestRouteMetadataReplaced(t *testing.T) {
+	resetRoutes(t)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:1/sse","tags":["old"],"owner":"a"}`)
+	register(t, `{"server_name":"search","server_url":"http://127.0.0.1:2/sse","tags":["new"]}`)
+	if md := routeMetadataFor("/search"); md.Owner != "" || len(md.Tags) != 1 || md.Tags[0] != "new" {
+		t.Errorf("metadata after re-register = %+v", md)
+	}
+
+	// 带版本的后端没有自己的描述信息时沿用名称的描述信息
+	routeMapLock.Lock()
+	routeMap["/search@v3"] = "http://127.0.0.1:3/sse"
+	routeMapLock.Unlock()
+	if md := routeMetadataFor("/search@v3"); md.Version != "v3" || len(md.Tags) != 1 || md.Tags[0] != "new" {
+		t.Errorf("inherited metadata = %+v", md)
+	}
+}
+
+// Overview 与 Register 并发执行时不能死锁，需要以 -race 运行
+func TestOverviewConcurrentRegister(t *testing.T) {
+	resetRoutes(t)
+	for i := range 50 {
+		register(t, fmt.Sprintf(`{"server_name":"s%d","server_url":"http://127.0.0.1:%d/sse","tags":["t"]}`, i, i+1))
+		cacheServerInfo(fmt.Sprintf("/s%d", i))
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"server_name":"s%d","server_url":"http://127.0.0.1:%d/sse","tags":["t"]}`, i, i+1)
+			for range 200 {
+				rec := httptest.NewRecorder()
+				Register(rec, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body)))
+				if rec.Code != http.StatusOK {
+					t.Errorf("register: status %d", rec.Code)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 200 {
+				rec := httptest.NewRecorder()
+				Overview(rec, httptest.NewRequest(http.MethodGet, "/overview?tag=t", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("overview: status %d", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -1,52 +1,81 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
+// upstreamTransport 是访问后端的传输层，为上游请求创建 client span 并注入 traceparent
+// tools/call 的 POST 可能在后端执行完工具后才返回响应头，不设置响应头超时；
+// 空闲连接池沿用默认超时，进行中的 SSE 流由保活与空闲超时管理
+// 外层的 resilientTransport 负责熔断与重试，每次尝试各自产生一个 span
+var upstreamTransport http.RoundTripper = &resilientTransport{
+	next: otelhttp.NewTransport(http.DefaultTransport.(*http.Transport).Clone()),
+}
+
+// upstreamClient 供网关主动访问后端，如重建会话
+var upstreamClient = &http.Client{Transport: upstreamTransport}
+
 // 创建反向代理的辅助函数
 func createReverseProxy(targetURL *url.URL) *httputil.ReverseProxy {
 	proxy := httputil.NewSingleHostReverseProxy(targetURL)
-
-	// 自定义传输层以支持 SSE
-	defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
-	defaultTransport.ResponseHeaderTimeout = 0 // SSE 需要长连接
-	defaultTransport.IdleConnTimeout = 0       // 防止空闲连接超时
-
-	proxy.Transport = defaultTransport
+	proxy.Transport = upstreamTransport
 
 	// 自定义代理的 Director 函数
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
+		// 认证已在网关完成，网关的 API Key 不转发给后端
+		stripAPIKey(req, config())
 		// 可以在这里修改请求头
 		req.Header.Set("X-Proxy", "Go-Reverse-Proxy")
 
 		// 从请求上下文中获取源URL
-		if sourceURL, ok := req.Context().Value(sourceURLKey).(string); ok {
-			log.Printf("代理请求: %s %s -> %s", req.Method, sourceURL, req.URL.String())
-		} else {
-			log.Printf("代理请求: %s %s -> %s", req.Method, req.URL.String(), req.URL.String())
+		sourceURL, ok := req.Context().Value(sourceURLKey).(string)
+		if !ok {
+			sourceURL = req.URL.String()
 		}
+		loggerFrom(req.Context()).Debug("proxy request", "method", req.Method, "source", sourceURL, "upstream", req.URL.String())
 	}
 
 	// 自定义错误处理
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("代理错误: %v", err)
-		w.WriteHeader(http.StatusBadGateway)
-		w.Write([]byte("代理服务器错误"))
+		// 网关已代替后端向客户端返回了结果（超时或客户端取消），转发中的请求被主动结束
+		if errors.Is(context.Cause(r.Context()), errCallAbandoned) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if prefix, ok := r.Context().Value(prefixKey).(string); ok {
+			proxyErrorsTotal.WithLabelValues(prefix).Inc()
+		}
+
+		// 后端熔断中，请求没有发出，提示客户端稍后重试
+		var open *circuitOpenError
+		if errors.As(err, &open) {
+			loggerFrom(r.Context()).Warn("upstream circuit open", "method", r.Method, "upstream", r.URL.String())
+			retryAfter := int(math.Ceil(open.retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			kind := kindUpstreamUnavailable
+			kind.Status = http.StatusServiceUnavailable
+			writeError(w, r, kind, "upstream is unavailable (circuit open), retry later", map[string]any{"retry_after": retryAfter})
+			return
+		}
+
+		loggerFrom(r.Context()).Error("proxy error", "method", r.Method, "upstream", r.URL.String(), "error", err)
+		writeError(w, r, kindUpstreamUnavailable, "upstream is unavailable", nil)
 	}
 
 	// 自定义修改响应
 	proxy.ModifyResponse = func(resp *http.Response) error {
 		// 添加 CORS 头部
-		resp.Header.Set("Access-Control-Allow-Origin", "*") // 或者指定域名
-		resp.Header.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		resp.Header.Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		resp.Header.Set("Access-Control-Allow-Credentials", "true")
+		setCORSHeaders(resp.Header)
 
 		// 对于 SSE 响应，确保不会缓存并修改内容
 		if resp.Header.Get("Content-Type") == "text/event-stream" {
@@ -59,19 +88,27 @@ func createReverseProxy(targetURL *url.URL) *httputil.ReverseProxy {
 				requestPrefix = prefixVal.(string)
 			}
 
-			// 拦截并修改 SSE 响应内容
-			originalBody := resp.Body
-
-			// 创建一个自定义的响应体读取器，传入前缀
-			sseModifier := &sseResponseModifier{
-				original: originalBody,
-				inEvent:  false,
-				event:    "",
-				prefix:   requestPrefix, // 传递前缀到修改器
+			// 上游连接交给会话持有，客户端读取的是会话的事件流
+			clientCtx := resp.Request.Context()
+			upstream := &sseUpstream{
+				body:    resp.Body,
+				prefix:  requestPrefix,
+				request: resp.Request,
+			}
+			if sc := streamFrom(resp.Request.Context()); sc != nil {
+				sc.owned = true
+				clientCtx = sc.client
+				upstream.cancel = sc.cancel
 			}
+			session := newSession(requestPrefix, resp.Request.URL.String(), resp.Request)
+			session.upstream = upstream
+			upstream.session = session
+
+			stream, _ := newClientStream(clientCtx, session, 0)
+			go upstream.run()
 
 			// 替换原始响应体
-			resp.Body = sseModifier
+			resp.Body = stream
 		}
 		return nil
 	}
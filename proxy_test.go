@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUpstream 是一个最小的 MCP SSE 后端：GET /sse 下发 endpoint，
+// POST /message 返回 202，响应经由对应会话的 SSE 流下发
+type fakeUpstream struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	next     int
+	streams  map[string]chan []byte
+	received []*jsonrpcMessage
+
+	// handle 返回请求的 result，为 nil 时不回复；在独立协程中调用，可以阻塞
+	handle func(msg *jsonrpcMessage) any
+}
+
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	u := &fakeUpstream{streams: map[string]chan []byte{}}
+	u.handle = func(msg *jsonrpcMessage) any {
+		return map[string]any{"method": msg.Method}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", u.serveSSE)
+	mux.HandleFunc("/message", u.serveMessage)
+	u.Server = httptest.NewServer(mux)
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *fakeUpstream) serveSSE(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	u.next++
+	id := fmt.Sprintf("up-%d", u.next)
+	stream := make(chan []byte, 16)
+	u.streams[id] = stream
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		delete(u.streams, id)
+		u.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "event: endpoint\ndata: /message?sessionId=%s\n\n", id)
+	w.(http.Flusher).Flush()
+	for {
+		select {
+		case data := <-stream:
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (u *fakeUpstream) serveMessage(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("sessionId")
+	u.mu.Lock()
+	_, ok := u.streams[id]
+	u.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	msg, ok := parseJSONRPC(body)
+	if !ok {
+		http.Error(w, "invalid message", http.StatusBadRequest)
+		return
+	}
+	u.mu.Lock()
+	u.received = append(u.received, msg)
+	u.mu.Unlock()
+	w.WriteHeader(http.StatusAccepted)
+
+	if msg.isRequest() {
+		go func() {
+			if result := u.handle(msg); result != nil {
+				u.send(id, map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+			}
+		}()
+	}
+}
+
+// send 向后端会话的 SSE 流推送一条消息，会话不存在时忽略
+func (u *fakeUpstream) send(id string, msg any) {
+	data, _ := json.Marshal(msg)
+	u.mu.Lock()
+	stream := u.streams[id]
+	u.mu.Unlock()
+	if stream != nil {
+		stream <- data
+	}
+}
+
+// methods 返回后端收到的消息的方法名
+func (u *fakeUpstream) methods() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var methods []string
+	for _, msg := range u.received {
+		methods = append(methods, msg.Method)
+	}
+	return methods
+}
+
+// newTestGateway 将 prefix 路由到 upstream，返回只服务该路由的网关
+func newTestGateway(t *testing.T, prefix, upstream string) *httptest.Server {
+	routeMapLock.Lock()
+	routeMap[prefix] = upstream
+	delete(proxyMap, prefix)
+	routeMapLock.Unlock()
+	t.Cleanup(func() {
+		routeMapLock.Lock()
+		delete(routeMap, prefix)
+		delete(proxyMap, prefix)
+		routeMapLock.Unlock()
+	})
+
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		getOrCreateProxy(prefix).ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		// 恢复窗口内保留的会话仍持有上游连接，先关闭会话再关闭服务器
+		for _, s := range sessionsSnapshot(prefix) {
+			s.close()
+		}
+		gw.Close()
+	})
+	return gw
+}
+
+// sseClient 连接网关的 SSE 端点并逐个读取事件
+type sseClient struct {
+	t        *testing.T
+	base     string
+	resp     *http.Response
+	events   chan sseEvent
+	endpoint string
+}
+
+func dialSSE(t *testing.T, target string, header http.Header) *sseClient {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		t.Fatalf("GET %s: %d %s", target, resp.StatusCode, body)
+	}
+	u, _ := url.Parse(target)
+	c := &sseClient{t: t, base: u.Scheme + "://" + u.Host, resp: resp, events: make(chan sseEvent, 64)}
+	t.Cleanup(c.close)
+
+	go func() {
+		defer close(c.events)
+		var parser sseParser
+		reader := bufio.NewReader(resp.Body)
+		buf := make([]byte, 4096)
+		for {
+			n, err := reader.Read(buf)
+			for _, event := range parser.feed(buf[:n]) {
+				c.events <- event
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *sseClient) close() {
+	c.resp.Body.Close()
+}
+
+// next 返回下一个事件，超时或流结束时 ok 为 false
+func (c *sseClient) next(timeout time.Duration) (sseEvent, bool) {
+	select {
+	case event, ok := <-c.events:
+		return event, ok
+	case <-time.After(timeout):
+		return sseEvent{}, false
+	}
+}
+
+// nextMessage 跳过注释与其他事件，返回下一个 message 事件中的 JSON-RPC 消息
+func (c *sseClient) nextMessage(timeout time.Duration) *jsonrpcMessage {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		event, ok := c.next(time.Until(deadline))
+		if !ok {
+			c.t.Fatal("no message event before timeout")
+		}
+		if event.Event == "message" {
+			msg, ok := parseJSONRPC([]byte(event.Data))
+			if !ok {
+				c.t.Fatalf("invalid message event %q", event.Data)
+			}
+			return msg
+		}
+	}
+}
+
+// waitEndpoint 读取 endpoint 事件，记录后续 POST 使用的地址
+func (c *sseClient) waitEndpoint() string {
+	c.t.Helper()
+	for {
+		event, ok := c.next(5 * time.Second)
+		if !ok {
+			c.t.Fatal("no endpoint event")
+		}
+		if event.Event == "endpoint" {
+			u, err := url.Parse(event.Data)
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			c.endpoint = c.base + u.RequestURI()
+			return event.Data
+		}
+	}
+}
+
+// post 向 message 端点发送 JSON-RPC 消息，返回状态码与响应体
+func (c *sseClient) post(body string, header http.Header) (int, string) {
+	c.t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, c.endpoint, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(bytes.TrimSpace(data))
+}
+
+func sseTestConfig(t *testing.T) *Config {
+	cfg := defaultConfig()
+	cfg.SSE = SSEConfig{}
+	useConfig(t, cfg)
+	return cfg
+}
+
+func TestProxyRoundTrip(t *testing.T) {
+	resetRoutes(t)
+	sseTestConfig(t)
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	c := dialSSE(t, gw.URL+"/search/sse", nil)
+	if endpoint := c.waitEndpoint(); !strings.Contains(endpoint, "/search/message?sessionId=") {
+		t.Fatalf("endpoint %q not rewritten to the gateway", endpoint)
+	}
+	if code, body := c.post(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, nil); code != http.StatusAccepted {
+		t.Fatalf("POST: %d %s", code, body)
+	}
+	if msg := c.nextMessage(5 * time.Second); string(msg.ID) != "1" || !strings.Contains(string(msg.Result), "tools/list") {
+		t.Errorf("response = %+v", msg)
+	}
+}
+
+func TestSSEKeepAlive(t *testing.T) {
+	resetRoutes(t)
+	cfg := sseTestConfig(t)
+	cfg.SSE.KeepAlive = 50 * time.Millisecond
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	c := dialSSE(t, gw.URL+"/search/sse", nil)
+	c.waitEndpoint()
+	// 空闲的流上注入注释事件，客户端解析时忽略
+	event, ok := c.next(time.Second)
+	if !ok || len(event.Comments) != 1 || event.Comments[0] != " keepalive" || event.HasData {
+		t.Fatalf("got %+v, %v; want keepalive comment", event, ok)
+	}
+}
+
+func TestSSEUpstreamIdleTimeout(t *testing.T) {
+	resetRoutes(t)
+	cfg := sseTestConfig(t)
+	cfg.SSE.UpstreamIdleTimeout = 100 * time.Millisecond
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	c := dialSSE(t, gw.URL+"/search/sse", nil)
+	c.waitEndpoint()
+	// 上游长时间没有数据时结束客户端的流
+	for {
+		event, ok := c.next(2 * time.Second)
+		if !ok {
+			break
+		}
+		if len(event.Comments) == 0 {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+	select {
+	case _, open := <-c.events:
+		if open {
+			t.Fatal("stream still open after upstream idle timeout")
+		}
+	default:
+		t.Fatal("stream still open after upstream idle timeout")
+	}
+}
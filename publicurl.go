@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const publicBaseKey contextKey = "publicBase"
+
+// trustedProxy 判断请求是否直接来自受信任的代理，只有此时才采用 X-Forwarded-* 头
+func (c *Config) trustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range c.trustedNets {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedValue 取转发头的第一个值，多级代理时它对应最外层客户端看到的地址
+func forwardedValue(r *http.Request, name string) string {
+	v, _, _ := strings.Cut(r.Header.Get(name), ",")
+	return strings.TrimSpace(v)
+}
+
+// publicBaseURL 计算客户端访问网关使用的基础地址，Path 为网关挂载的子路径（不带末尾斜杠）
+// 依次以 public_url 或请求本身的 scheme 与 Host 为准，受信任代理的
+// X-Forwarded-Proto、X-Forwarded-Host、X-Forwarded-Prefix 覆盖对应部分
+func publicBaseURL(r *http.Request) *url.URL {
+	cfg := config()
+
+	base := &url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	if cfg.PublicURL != "" {
+		if u, err := url.Parse(cfg.PublicURL); err == nil {
+			base = &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+		}
+	}
+
+	if cfg.trustedProxy(r) {
+		if proto := strings.ToLower(forwardedValue(r, "X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			base.Scheme = proto
+		}
+		if host := forwardedValue(r, "X-Forwarded-Host"); host != "" && !strings.ContainsAny(host, "/\\@ ") {
+			base.Host = host
+		}
+		if prefix := forwardedValue(r, "X-Forwarded-Prefix"); prefix != "" {
+			base.Path = prefix
+		}
+	}
+
+	base.Path = strings.TrimRight(path.Clean("/"+base.Path), "/")
+	return base
+}
+
+// publicBaseFrom 取出 prefixMiddleware 记录的基础地址，不存在时由请求计算
+func publicBaseFrom(r *http.Request) *url.URL {
+	if base, ok := r.Context().Value(publicBaseKey).(*url.URL); ok {
+		u := *base
+		return &u
+	}
+	return publicBaseURL(r)
+}
+
+// rewriteEndpoint 将上游 endpoint 事件中的地址改写为客户端可访问的网关地址
+// 相对地址按上游请求解析，路径挂到 base 的子路径与路由前缀之下，查询参数原样保留
+func rewriteEndpoint(endpoint string, upstream *url.URL, base *url.URL, prefix string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		return "", err
+	}
+	if upstream != nil {
+		u = upstream.ResolveReference(u)
+	}
+
+	rewritten := *base
+	rewritten.Path = base.Path + prefix + u.Path
+	rewritten.RawPath = ""
+	if u.RawPath != "" {
+		rewritten.RawPath = base.EscapedPath() + prefix + u.RawPath
+	}
+	rewritten.RawQuery = u.RawQuery
+	rewritten.Fragment = ""
+	return rewritten.String(), nil
+}
+
+// mountMiddleware 支持外层代理不剥离子路径的部署：public_url 带路径时，
+// 以该路径开头的请求先去掉子路径再分发，未带子路径的请求保持不变
+func mountMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mount := ""
+		if u, err := url.Parse(config().PublicURL); err == nil {
+			mount = strings.TrimRight(u.Path, "/")
+		}
+		if mount != "" && (r.URL.Path == mount || strings.HasPrefix(r.URL.Path, mount+"/")) {
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, mount), "/")
+			r2.URL.RawPath = ""
+			r = r2
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
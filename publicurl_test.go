@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+)
+
+func TestRewriteEndpoint(t *testing.T) {
+	upstream, _ := url.Parse("http://10.0.0.5:8080/mcp/sse")
+	tests := []struct {
+		name     string
+		endpoint string
+		base     string
+		prefix   string
+		want     string
+	}{
+		{
+			name:     "absolute path",
+			endpoint: "/message?sessionId=abc",
+			base:     "http://gateway.example.com",
+			prefix:   "/search",
+			want:     "http://gateway.example.com/search/message?sessionId=abc",
+		},
+		{
+			name:     "relative to upstream path",
+			endpoint: "message?sessionId=abc",
+			base:     "http://gateway.example.com",
+			prefix:   "/search",
+			want:     "http://gateway.example.com/search/mcp/message?sessionId=abc",
+		},
+		{
+			name:     "absolute url of upstream host",
+			endpoint: "http://10.0.0.5:8080/message?sessionId=abc",
+			base:     "https://gateway.example.com",
+			prefix:   "/search",
+			want:     "https://gateway.example.com/search/message?sessionId=abc",
+		},
+		{
+			name:     "base with sub path",
+			endpoint: "/message?sessionId=abc",
+			base:     "https://example.com/mcp",
+			prefix:   "/search",
+			want:     "https://example.com/mcp/search/message?sessionId=abc",
+		},
+		{
+			name:     "escaped path and fragment",
+			endpoint: " /a%2Fb/message?sessionId=abc#frag\n",
+			base:     "http://gateway.example.com",
+			prefix:   "/search",
+			want:     "http://gateway.example.com/search/a%2Fb/message?sessionId=abc",
+		},
+	}
+	for _, tt := range tests {
+		base, _ := url.Parse(tt.base)
+		got, err := rewriteEndpoint(tt.endpoint, upstream, base, tt.prefix)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := rewriteEndpoint("http://[::1", upstream, &url.URL{}, "/search"); err == nil {
+		t.Error("invalid endpoint: expected error")
+	}
+}
+
+func TestPublicBaseURL(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.trustedNets = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	useConfig(t, cfg)
+
+	forwarded := func(remote string) string {
+		r := httptest.NewRequest("GET", "http://internal:3000/search/sse", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "mcp.example.com")
+		r.Header.Set("X-Forwarded-Prefix", "/gw/")
+		return publicBaseURL(r).String()
+	}
+	if got := forwarded("10.1.2.3:5000"); got != "https://mcp.example.com/gw" {
+		t.Errorf("trusted proxy: got %q", got)
+	}
+	// 不受信任的来源不能通过转发头改写地址
+	if got := forwarded("192.0.2.1:5000"); got != "http://internal:3000" {
+		t.Errorf("untrusted client: got %q", got)
+	}
+
+	cfg.PublicURL = "https://public.example.com/base/"
+	if got := forwarded("192.0.2.1:5000"); got != "https://public.example.com/base" {
+		t.Errorf("public_url: got %q", got)
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_client "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var quotaExceededTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mcp_gateway_quota_exceeded_total",
+	Help: "tools/call requests made after a quota was exhausted, by rule and action taken.",
+}, []string{"route", "rule", "action"})
+
+// usageKey 是用量统计的维度
+type usageKey struct {
+	Tenant string `json:"tenant"`
+	User   string `json:"user"`
+	KeyID  string `json:"key_id,omitempty"` // API Key 的摘要，见 apiKeyID
+	Route  string `json:"route"`
+	Tool   string `json:"tool"`
+}
+
+// usageEntry 是用量文件与 /admin/usage 中的一行
+type usageEntry struct {
+	usageKey
+	Calls int64 `json:"calls"`
+}
+
+// usageTracker 按自然日与自然月累计 tools/call 次数，周期按网关所在时区划分
+type usageTracker struct {
+	mu      sync.Mutex
+	periods map[string]map[usageKey]int64 // 键为 "2006-01-02"（日）或 "2006-01"（月）
+	dirty   bool
+}
+
+var usage = &usageTracker{periods: map[string]map[usageKey]int64{}}
+
+// 用量保留时长，更早的日、月统计在保存时删除
+const (
+	usageKeepDays   = 92
+	usageKeepMonths = 25
+)
+
+// 用量写入文件的间隔
+const usageSaveInterval = 30 * time.Second
+
+// periodKey 返回时间 t 所在周期的键
+func periodKey(period string, t time.Time) string {
+	if period == "day" {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01")
+}
+
+// periodEnd 返回时间 t 所在周期结束（下一周期开始）的时间
+func periodEnd(period string, t time.Time) time.Time {
+	y, m, d := t.Date()
+	if period == "day" {
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+}
+
+// matches 判断规则是否统计这一行用量
+func (rule *QuotaRuleConfig) matches(k usageKey) bool {
+	if len(rule.Tenants) > 0 && !slices.Contains(rule.Tenants, k.Tenant) {
+		return false
+	}
+	if len(rule.Routes) > 0 && !slices.Contains(rule.Routes, k.Route) {
+		return false
+	}
+	if len(rule.Tools) > 0 && !slices.Contains(rule.Tools, k.Tool) {
+		return false
+	}
+	return true
+}
+
+// anonymousSubject 是未认证调用（按 api_key 计数）或没有租户的 API Key（按 tenant 计数）共用的配额主体
+const anonymousSubject = "anonymous"
+
+// subject 返回这一行用量归属的配额主体：租户，或按 api_key 计数时 API Key 的摘要
+// 没有租户或未认证的调用都计入 anonymousSubject，共享同一份配额
+func (rule *QuotaRuleConfig) subject(k usageKey) string {
+	subject := k.Tenant
+	if rule.Per == "api_key" {
+		subject = k.KeyID
+	}
+	if subject == "" {
+		return anonymousSubject
+	}
+	return subject
+}
+
+// usedLocked 统计主体在规则周期内已用的次数，调用方需持有 mu
+func (t *usageTracker) usedLocked(rule *QuotaRuleConfig, subject string, now time.Time) int64 {
+	var used int64
+	for k, calls := range t.periods[periodKey(rule.Period, now)] {
+		if rule.subject(k) == subject && rule.matches(k) {
+			used += calls
+		}
+	}
+	return used
+}
+
+// recordLocked 累计一次调用，调用方需持有 mu
+func (t *usageTracker) recordLocked(k usageKey, now time.Time) {
+	for _, period := range []string{"day", "month"} {
+		key := periodKey(period, now)
+		if t.periods[key] == nil {
+			t.periods[key] = map[usageKey]int64{}
+		}
+		t.periods[key][k]++
+	}
+	t.dirty = true
+}
+
+// quotaDecision 是一次 tools/call 的配额检查结果，rule 为 nil 表示未超出任何配额
+type quotaDecision struct {
+	rule    *QuotaRuleConfig
+	used    int64
+	resets  time.Time
+	subject string
+}
+
+// 超出多个配额时按 block、downgrade、warn 的顺序取最严格的处理
+var quotaActionOrder = map[string]int{"block": 3, "downgrade": 2, "warn": 1}
+
+// admit 检查调用是否超出配额并记账：被阻止的调用不计入用量，降级的调用计入降级路由
+func (t *usageTracker) admit(k usageKey, now time.Time) quotaDecision {
+	rules := config().Quotas.Rules
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var decision quotaDecision
+	for i := range rules {
+		rule := &rules[i]
+		subject := rule.subject(k)
+		if !rule.matches(k) {
+			continue
+		}
+		used := t.usedLocked(rule, subject, now)
+		if used < rule.Limit {
+			continue
+		}
+		if decision.rule == nil || quotaActionOrder[rule.Action] > quotaActionOrder[decision.rule.Action] {
+			decision = quotaDecision{rule: rule, used: used, resets: periodEnd(rule.Period, now), subject: subject}
+		}
+	}
+
+	switch {
+	case decision.rule == nil || decision.rule.Action == "warn":
+		t.recordLocked(k, now)
+	case decision.rule.Action == "downgrade":
+		k.Route = decision.rule.DowngradeTo
+		t.recordLocked(k, now)
+	}
+	return decision
+}
+
+// load 读取用量文件，文件不存在时从零开始
+func (t *usageTracker) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var file map[string][]usageEntry
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, entries := range file {
+		counts := map[usageKey]int64{}
+		for _, e := range entries {
+			counts[e.usageKey] += e.Calls
+		}
+		t.periods[key] = counts
+	}
+	return nil
+}
+
+// save 删除过期的统计后原子地写入用量文件，没有变化时不写
+func (t *usageTracker) save(path string) error {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	oldestDay := periodKey("day", now.AddDate(0, 0, -usageKeepDays))
+	oldestMonth := periodKey("month", now.AddDate(0, -usageKeepMonths, 0))
+	file := map[string][]usageEntry{}
+	for key, counts := range t.periods {
+		if (len(key) == len(oldestDay) && key < oldestDay) || (len(key) == len(oldestMonth) && key < oldestMonth) {
+			delete(t.periods, key)
+			continue
+		}
+		for k, calls := range counts {
+			file[key] = append(file[key], usageEntry{usageKey: k, Calls: calls})
+		}
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	data, err := json.Marshal(file)
+	if err == nil {
+		tmp := path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0o600); err == nil {
+			err = os.Rename(tmp, path)
+		}
+	}
+	if err != nil {
+		// 写入失败时保留未保存标记，下次重试
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// persistUsage 定期将用量写入 quotas.store，未配置时用量只保存在内存中
+func persistUsage(path string) {
+	for range time.Tick(usageSaveInterval) {
+		if err := usage.save(path); err != nil {
+			logger.Warn("failed to save usage", "path", path, "error", err)
+		}
+	}
+}
+
+// quotaUsageKey 返回请求在用量统计中的维度，租户、用户与 API Key 摘要取自 API Key，未认证的调用三者为空
+// 各版本的用量计入路由名称
+func quotaUsageKey(r *http.Request, prefix, tool string) usageKey {
+	k := usageKey{Route: routeName(prefix), Tool: tool}
+	if key := apiKeyFrom(r.Context()); key != nil {
+		k.Tenant, k.User, k.KeyID = key.Tenant, key.User, apiKeyID(key)
+	}
+	return k
+}
+
+// apiKeyID 返回 API Key 的稳定标识（摘要的前 16 位），用户名可能重复或变更，不适合作为配额主体
+func apiKeyID(key *APIKeyConfig) string {
+	if key == nil {
+		return ""
+	}
+	return hashAPIKey(key)[:16]
+}
+
+// serveDowngraded 由降级路由的后端完成 tools/call，与转发到后端的调用一样记录链路、审计、指标与录制
+// 降级调用失败时向客户端返回配额错误
+func serveDowngraded(w http.ResponseWriter, r *http.Request, session *mcpSession, msg *jsonrpcMessage, params *toolCallParams, downgradeTo, message string, data map[string]any) {
+	log := loggerFrom(r.Context()).With("tool", params.Name, "downgrade_to", downgradeTo)
+	ctx := r.Context()
+	timeout := config().policyFor(session.Prefix).callTimeout(params.Name)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	span := startCallSpan(ctx, session.Prefix, msg)
+	span.SetAttributes(attribute.String("mcp.downgrade_to", downgradeTo))
+	call := &pendingCall{ID: msg.ID, Method: msg.Method, Tool: params.Name, Args: params.Arguments, Started: time.Now(), Span: span, downgradeTo: downgradeTo}
+	session.requests.Add(1)
+	session.toolCalls.Add(1)
+
+	result, err := callDowngraded(ctx, downgradeTo, params)
+	kind := kindQuotaExceeded
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Warn("downgraded tool call timed out", "timeout", timeout.String())
+		kind, message, data = kindTimeout, fmt.Sprintf("tool %q timed out after %s", params.Name, timeout), map[string]any{"tool": params.Name, "timeout": timeout.String()}
+	case err != nil:
+		log.Error("downgraded tool call failed", "error", err)
+	}
+
+	resp := jsonrpcMessage{JSONRPC: mcp.JSONRPC_VERSION, ID: msg.ID}
+	if err != nil {
+		resp.Error = &jsonrpcError{Code: kind.Code, Message: message, Data: map[string]any{"type": kind.Type}}
+	} else {
+		resp.Result, _ = json.Marshal(result)
+	}
+	endCallSpan(span, &resp)
+	auditor.Load().record(session, call, &resp, len(resp.Result))
+	observeToolCall(session, call, &resp)
+
+	if err != nil {
+		writeError(w, r, kind, message, data)
+		return
+	}
+	if raw, err := json.Marshal(resp); err == nil {
+		session.cassette.record("server", raw)
+	}
+	replyJSONRPC(w, session, http.StatusOK, resp)
+}
+
+// sharedClient 是网关连接后端的共享 MCP 客户端，配额降级与影子流量的调用复用同一个连接
+// 调用失败后丢弃，下一次调用重新连接
+type sharedClient struct {
+	target string
+	client *_client.SSEMCPClient
+	cancel context.CancelFunc // 结束客户端的 SSE 连接
+}
+
+var (
+	sharedClientsLock sync.Mutex
+	sharedClients     = map[string]*sharedClient{} // 键为后端地址
+)
+
+// 建立共享客户端连接的最长时间
+const sharedConnectTimeout = 30 * time.Second
+
+// sharedClientFor 返回后端的共享客户端，没有时建立连接；fresh 表示客户端是本次新建的
+func sharedClientFor(ctx context.Context, target string) (c *sharedClient, fresh bool, err error) {
+	sharedClientsLock.Lock()
+	defer sharedClientsLock.Unlock()
+	if c := sharedClients[target]; c != nil {
+		return c, false, nil
+	}
+
+	// SSE 连接在客户端的整个生命周期内保持，不能绑定到调用的 ctx；建立连接的过程随调用取消或超时
+	streamCtx, cancel := context.WithCancel(context.Background())
+	connectCtx, connectCancel := context.WithTimeout(ctx, sharedConnectTimeout)
+	defer connectCancel()
+	stop := context.AfterFunc(connectCtx, cancel)
+	client, err := connectMCP(streamCtx, target)
+	if !stop() && err == nil {
+		client.Close()
+		err = connectCtx.Err()
+	}
+	if err != nil {
+		cancel()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, false, err
+	}
+	c = &sharedClient{target: target, client: client, cancel: cancel}
+	sharedClients[target] = c
+	return c, true, nil
+}
+
+// drop 关闭客户端并从共享客户端中移除
+func (c *sharedClient) drop() {
+	sharedClientsLock.Lock()
+	if sharedClients[c.target] == c {
+		delete(sharedClients, c.target)
+	}
+	sharedClientsLock.Unlock()
+	c.client.Close()
+	c.cancel()
+}
+
+// callShared 以后端的共享客户端完成一次工具调用
+// 复用的连接可能已被后端关闭，失败时以新连接重试一次
+func callShared(ctx context.Context, target string, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	for {
+		c, fresh, err := sharedClientFor(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		result, err := c.client.CallTool(ctx, request)
+		if err == nil {
+			return result, nil
+		}
+		c.drop()
+		if fresh || ctx.Err() != nil {
+			return nil, err
+		}
+	}
+}
+
+// callDowngraded 在降级路由的后端上完成一次工具调用，网关以共享的 MCP 客户端连接执行
+func callDowngraded(ctx context.Context, prefix string, params *toolCallParams) (*mcp.CallToolResult, error) {
+	target, ok := getRoutes()[prefix]
+	if !ok {
+		return nil, fmt.Errorf("route %s is not available", prefix)
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Name = params.Name
+	request.Params.Arguments = params.Arguments
+	return callShared(ctx, target, request)
+}
+
+// connectMCP 以网关自己的身份连接后端的 SSE 地址并完成 initialize，调用方负责关闭客户端
+func connectMCP(ctx context.Context, target string) (*_client.SSEMCPClient, error) {
+	client, err := _client.NewSSEMCPClient(target)
+	if err != nil {
+		return nil, fmt.Errorf("create client: %w", err)
+	}
+	if err := client.Start(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("start client: %w", err)
+	}
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{Name: "mcp-gateway", Version: "1.0.0"}
+	if _, err := client.Initialize(ctx, initRequest); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	return client, nil
+}
+
+// quotaStatus 是 /admin/usage 中一个主体在一条规则下的当前用量
+type quotaStatus struct {
+	Rule     string    `json:"rule"`
+	Per      string    `json:"per"`
+	Subject  string    `json:"subject"`
+	Period   string    `json:"period"`
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit"`
+	Action   string    `json:"action"`
+	Exceeded bool      `json:"exceeded"`
+	Resets   time.Time `json:"resets"`
+}
+
+// usageReport 是 /admin/usage 的响应
+type usageReport struct {
+	Period  string           `json:"period"`
+	Total   int64            `json:"total"`
+	ByRoute map[string]int64 `json:"by_route"`
+	ByTool  map[string]int64 `json:"by_tool"`
+	Usage   []usageEntry     `json:"usage"`
+	Quotas  []quotaStatus    `json:"quotas"`
+}
+
+// AdminUsage 处理 GET /admin/usage：按路由与工具汇总某个周期的调用次数，并列出各配额的当前用量
+// period 为 day 或 month（默认），date 指定周期（如 2025-01-02 或 2025-01，默认当前周期），
+// tenant、user、route 过滤结果
+func AdminUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	query := r.URL.Query()
+	now := time.Now()
+	period := query.Get("period")
+	if period == "" {
+		period = "month"
+	}
+	if period != "day" && period != "month" {
+		writeError(w, r, kindBadRequest, "period must be day or month", nil)
+		return
+	}
+	key := periodKey(period, now)
+	if date := query.Get("date"); date != "" {
+		if len(date) != len(key) {
+			writeError(w, r, kindBadRequest, "date does not match period", nil)
+			return
+		}
+		key = date
+	}
+	route := query.Get("route")
+	if route != "" {
+		route = "/" + strings.Trim(route, "/")
+	}
+	filter := func(k usageKey) bool {
+		return (query.Get("tenant") == "" || k.Tenant == query.Get("tenant")) &&
+			(query.Get("user") == "" || k.User == query.Get("user")) &&
+			(route == "" || k.Route == route)
+	}
+
+	report := usageReport{Period: key, ByRoute: map[string]int64{}, ByTool: map[string]int64{}, Usage: []usageEntry{}, Quotas: []quotaStatus{}}
+	rules := config().Quotas.Rules
+
+	usage.mu.Lock()
+	for k, calls := range usage.periods[key] {
+		if !filter(k) {
+			continue
+		}
+		report.Total += calls
+		report.ByRoute[k.Route] += calls
+		report.ByTool[k.Tool] += calls
+		report.Usage = append(report.Usage, usageEntry{usageKey: k, Calls: calls})
+	}
+	for i := range rules {
+		rule := &rules[i]
+		subjects := map[string]bool{}
+		for k := range usage.periods[periodKey(rule.Period, now)] {
+			if subject := rule.subject(k); rule.matches(k) && filter(k) {
+				subjects[subject] = true
+			}
+		}
+		for subject := range subjects {
+			used := usage.usedLocked(rule, subject, now)
+			report.Quotas = append(report.Quotas, quotaStatus{
+				Rule:     rule.Name,
+				Per:      rule.Per,
+				Subject:  subject,
+				Period:   periodKey(rule.Period, now),
+				Used:     used,
+				Limit:    rule.Limit,
+				Action:   rule.Action,
+				Exceeded: used >= rule.Limit,
+				Resets:   periodEnd(rule.Period, now),
+			})
+		}
+	}
+	usage.mu.Unlock()
+
+	sort.Slice(report.Usage, func(i, j int) bool {
+		a, b := report.Usage[i], report.Usage[j]
+		if a.Calls != b.Calls {
+			return a.Calls > b.Calls
+		}
+		return fmt.Sprint(a.usageKey) < fmt.Sprint(b.usageKey)
+	})
+	sort.Slice(report.Quotas, func(i, j int) bool {
+		a, b := report.Quotas[i], report.Quotas[j]
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Subject < b.Subject
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotaPeriods(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*3600)
+	tests := []struct {
+		period string
+		at     time.Time
+		key    string
+		end    time.Time
+	}{
+		{"day", time.Date(2025, 3, 9, 23, 59, 0, 0, loc), "2025-03-09", time.Date(2025, 3, 10, 0, 0, 0, 0, loc)},
+		{"day", time.Date(2024, 2, 29, 0, 0, 0, 0, loc), "2024-02-29", time.Date(2024, 3, 1, 0, 0, 0, 0, loc)},
+		{"day", time.Date(2025, 12, 31, 12, 0, 0, 0, loc), "2025-12-31", time.Date(2026, 1, 1, 0, 0, 0, 0, loc)},
+		{"month", time.Date(2025, 1, 31, 8, 0, 0, 0, loc), "2025-01", time.Date(2025, 2, 1, 0, 0, 0, 0, loc)},
+		{"month", time.Date(2025, 12, 1, 0, 0, 0, 0, loc), "2025-12", time.Date(2026, 1, 1, 0, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := periodKey(tt.period, tt.at); got != tt.key {
+			t.Errorf("periodKey(%s, %s) = %s, want %s", tt.period, tt.at, got, tt.key)
+		}
+		if got := periodEnd(tt.period, tt.at); !got.Equal(tt.end) {
+			t.Errorf("periodEnd(%s, %s) = %s, want %s", tt.period, tt.at, got, tt.end)
+		}
+	}
+}
+
+func TestQuotaAdmit(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Quotas.Rules = []QuotaRuleConfig{
+		{Name: "daily", Per: "tenant", Routes: []string{"/search"}, Period: "day", Limit: 2, Action: "block"},
+		{Name: "monthly", Per: "api_key", Period: "month", Limit: 3, Action: "warn"},
+	}
+	useConfig(t, cfg)
+	tracker := &usageTracker{periods: map[string]map[usageKey]int64{}}
+
+	alice := usageKey{Tenant: "acme", User: "alice", KeyID: "k-alice", Route: "/search", Tool: "web_search"}
+	day1 := time.Date(2025, 1, 10, 10, 0, 0, 0, time.Local)
+	admit := func(k usageKey, at time.Time) string {
+		if d := tracker.admit(k, at); d.rule != nil {
+			return d.rule.Name
+		}
+		return ""
+	}
+
+	for i, want := range []string{"", "", "daily"} {
+		if got := admit(alice, day1); got != want {
+			t.Errorf("day 1 call %d: rule %q, want %q", i+1, got, want)
+		}
+	}
+	// 第二天日配额重置，月配额累计：被阻止的调用不计入用量
+	day2 := day1.Add(24 * time.Hour)
+	if got := admit(alice, day2); got != "" {
+		t.Errorf("day 2 first call: rule %q", got)
+	}
+	if got := admit(alice, day2); got != "monthly" {
+		t.Errorf("day 2 second call: rule %q, want monthly", got)
+	}
+
+	// 下个月月配额重置
+	if got := admit(alice, time.Date(2025, 2, 1, 0, 0, 0, 0, time.Local)); got != "" {
+		t.Errorf("next month: rule %q", got)
+	}
+
+	// 同一用户名的另一个 API Key 单独计算 api_key 配额
+	other := alice
+	other.KeyID, other.Route = "k-alice-2", "/files"
+	if d := tracker.admit(other, day2); d.rule != nil {
+		t.Errorf("other key: rule %q", d.rule.Name)
+	}
+
+	// 未认证的调用计入 anonymous，不会绕过配额
+	anon := usageKey{Route: "/search", Tool: "web_search"}
+	for i := range 3 {
+		d := tracker.admit(anon, day1)
+		if i < 2 && d.rule != nil || i == 2 && (d.rule == nil || d.subject != anonymousSubject) {
+			t.Errorf("anonymous call %d: %+v", i+1, d)
+		}
+	}
+}
+
+func TestUsageSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	now := time.Now()
+	k := usageKey{Tenant: "acme", User: "alice", KeyID: "k", Route: "/search", Tool: "web_search"}
+
+	saved := &usageTracker{periods: map[string]map[usageKey]int64{}}
+	saved.recordLocked(k, now)
+	saved.recordLocked(k, now)
+	// 超出保留期的日统计在保存时删除
+	old := periodKey("day", now.AddDate(0, 0, -usageKeepDays-1))
+	saved.periods[old] = map[usageKey]int64{k: 5}
+	if err := saved.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &usageTracker{periods: map[string]map[usageKey]int64{}}
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.periods[periodKey("day", now)][k]; got != 2 {
+		t.Errorf("day usage = %d, want 2", got)
+	}
+	if got := loaded.periods[periodKey("month", now)][k]; got != 2 {
+		t.Errorf("month usage = %d, want 2", got)
+	}
+	if _, ok := loaded.periods[old]; ok {
+		t.Errorf("expired day %s was kept", old)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	rateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mcp_gateway_rate_limited_total",
+		Help: "tools/call requests rejected by a rate limit rule.",
+	}, []string{"route", "tool", "rule"})
+
+	rateLimitRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_gateway_rate_limit_tokens_per_second",
+		Help: "Configured refill rate of each rate limit rule (route \"*\" is the default policy).",
+	}, []string{"route", "rule"})
+
+	rateLimitBurst = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mcp_gateway_rate_limit_burst",
+		Help: "Configured bucket size of each rate limit rule (route \"*\" is the default policy).",
+	}, []string{"route", "rule"})
+)
+
+// reportRateLimits 按配置更新限流规则指标，启动与热加载时调用
+func reportRateLimits(cfg *Config) {
+	rateLimitRate.Reset()
+	rateLimitBurst.Reset()
+	report := func(route string, rules []RateLimitConfig) {
+		for _, rule := range rules {
+			rateLimitRate.WithLabelValues(route, rule.Name).Set(rule.rate())
+			rateLimitBurst.WithLabelValues(route, rule.Name).Set(float64(rule.Burst))
+		}
+	}
+	report("*", cfg.Policies.RateLimits)
+	for _, route := range cfg.Routes {
+		report(route.Prefix, cfg.policyFor(route.Prefix).RateLimits)
+	}
+}
+
+// rate 返回每秒补充的令牌数
+func (rule RateLimitConfig) rate() float64 {
+	return float64(rule.Limit) / rule.Period.Seconds()
+}
+
+// appliesTo 判断规则是否作用于该工具
+func (rule RateLimitConfig) appliesTo(tool string) bool {
+	if len(rule.Tools) == 0 {
+		return true
+	}
+	for _, t := range rule.Tools {
+		if t == tool {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketKey 返回请求在该规则下对应的令牌桶
+// 键中包含规则参数，规则在热加载中变化后使用新的令牌桶；同一名称的各版本共用令牌桶
+func (rule RateLimitConfig) bucketKey(prefix, tool string, r *http.Request) string {
+	parts := []string{routeName(prefix), rule.Name, strconv.Itoa(rule.Limit), rule.Period.String(), strconv.Itoa(rule.Burst)}
+	for _, per := range rule.Per {
+		switch per {
+		case "tool":
+			parts = append(parts, "tool="+tool)
+		case "api_key":
+			parts = append(parts, "key="+hashAPIKey(apiKeyFrom(r.Context())))
+		case "client_ip":
+			parts = append(parts, "ip="+clientIP(r))
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// tokenBucket 记录桶中剩余的令牌与上次补充的时间，rate 为每秒补充的令牌数
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+// refill 按流逝的时间补充令牌
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// 令牌桶表，闲置到重新装满的桶会被定期清理
+var (
+	rateBuckets     = map[string]*tokenBucket{}
+	rateBucketsLock sync.Mutex
+	rateBucketSweep time.Time
+)
+
+// 清理闲置令牌桶的间隔
+const rateBucketSweepInterval = time.Minute
+
+// rateLimited 按路由策略检查一次 tools/call，被限流时返回触发的规则与建议的重试等待时间
+// 只有所有规则都放行时才从各个桶中取走令牌，被拒绝的调用不消耗任何额度
+func rateLimited(prefix, tool string, r *http.Request) (*RateLimitConfig, time.Duration) {
+	rules := config().policyFor(prefix).RateLimits
+	if len(rules) == 0 {
+		return nil, 0
+	}
+	now := time.Now()
+
+	rateBucketsLock.Lock()
+	defer rateBucketsLock.Unlock()
+	sweepRateBuckets(now)
+
+	var buckets []*tokenBucket
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.appliesTo(tool) {
+			continue
+		}
+		key := rule.bucketKey(prefix, tool, r)
+		b, ok := rateBuckets[key]
+		if !ok {
+			b = &tokenBucket{tokens: float64(rule.Burst), last: now, rate: rule.rate(), burst: float64(rule.Burst)}
+			rateBuckets[key] = b
+		}
+		b.refill(now)
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+			return rule, wait
+		}
+		buckets = append(buckets, b)
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return nil, 0
+}
+
+// sweepRateBuckets 删除已重新装满的令牌桶，调用方需持有 rateBucketsLock
+// 满桶与下次使用时新建的桶等价，规则变化后不再使用的旧桶也随之清除
+func sweepRateBuckets(now time.Time) {
+	if now.Sub(rateBucketSweep) < rateBucketSweepInterval {
+		return
+	}
+	rateBucketSweep = now
+	for key, b := range rateBuckets {
+		if b.refill(now); b.tokens >= b.burst {
+			delete(rateBuckets, key)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefill(t *testing.T) {
+	start := time.Now()
+	b := &tokenBucket{tokens: 0, last: start, rate: 2, burst: 5}
+
+	b.refill(start.Add(time.Second))
+	if b.tokens != 2 {
+		t.Errorf("after 1s: %g tokens, want 2", b.tokens)
+	}
+	b.refill(start.Add(10 * time.Second))
+	if b.tokens != 5 {
+		t.Errorf("after 10s: %g tokens, want burst 5", b.tokens)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	cfg := authConfig(t)
+	cfg.Policies.RateLimits = []RateLimitConfig{
+		{Name: "search", Tools: []string{"web_search"}, Limit: 1, Period: time.Hour, Burst: 2},
+		{Name: "per-key", Per: []string{"api_key"}, Limit: 1, Period: time.Hour, Burst: 3},
+	}
+	rateBucketsLock.Lock()
+	rateBuckets = map[string]*tokenBucket{}
+	rateBucketsLock.Unlock()
+
+	call := func(key *APIKeyConfig, tool string) string {
+		r := httptest.NewRequest("POST", "/search/message", nil)
+		r = r.WithContext(context.WithValue(r.Context(), apiKeyKey, key))
+		rule, wait := rateLimited("/search", tool, r)
+		if rule == nil {
+			return ""
+		}
+		if wait <= 0 || wait > time.Hour {
+			t.Errorf("%s limited by %s: wait %s", tool, rule.Name, wait)
+		}
+		return rule.Name
+	}
+	alice, bob := &cfg.Auth.APIKeys[0], &cfg.Auth.APIKeys[1]
+
+	// web_search 的桶共享，per-key 的桶按 API Key 划分
+	for i, want := range []string{"", "", "search"} {
+		if got := call(alice, "web_search"); got != want {
+			t.Errorf("web_search call %d: limited by %q, want %q", i+1, got, want)
+		}
+	}
+	// 被拒绝的调用不消耗其他规则的令牌：alice 的 per-key 桶还剩 1 个
+	if got := call(alice, "fetch"); got != "" {
+		t.Errorf("alice fetch: limited by %q", got)
+	}
+	if got := call(alice, "fetch"); got != "per-key" {
+		t.Errorf("alice second fetch: limited by %q, want per-key", got)
+	}
+	if got := call(bob, "fetch"); got != "" {
+		t.Errorf("bob fetch: limited by %q", got)
+	}
+	if got := call(bob, "web_search"); got != "search" {
+		t.Errorf("bob web_search: limited by %q, want search", got)
+	}
+}
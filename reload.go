@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 配置文件轮询间隔
+const configWatchInterval = 2 * time.Second
+
+// configPath 为空时没有配置文件，重载只重新读取环境变量
+var (
+	configPath string
+	reloadLock sync.Mutex
+)
+
+// reloadConfig 重新加载配置，校验失败时保留当前配置
+func reloadConfig(reason string) error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		logger.Error("config reload rejected", "reason", reason, "error", err)
+		return err
+	}
+	if err := applyConfig(config(), cfg); err != nil {
+		logger.Error("config reload failed", "reason", reason, "error", err)
+		return err
+	}
+	logger.Info("config reloaded", "reason", reason, "routes", len(cfg.Routes))
+	return nil
+}
+
+// applyConfig 将新配置应用到运行中的网关
+// 路由表与配置指针在同一把锁内替换，未变化的路由保留已有的代理处理器与会话
+func applyConfig(old, cfg *Config) error {
+	for _, field := range restartRequired(old, cfg) {
+		logger.Warn("config change requires restart", "field", field)
+	}
+	// 需要重启的字段保留运行中的值，重启前 config() 与实际生效的设置一致
+	cfg.Listeners = old.Listeners
+	cfg.Log.Format = old.Log.Format
+	cfg.Tracing = old.Tracing
+	cfg.Quotas.Store = old.Quotas.Store
+
+	if lvl, err := parseLogLevel(cfg.Log.Level); err == nil {
+		logLevel.Set(lvl)
+	}
+
+	if !reflect.DeepEqual(old.Audit, cfg.Audit) {
+		next, err := newAuditLogger(cfg.Audit)
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		auditor.Swap(next).Close()
+	}
+
+	reportRateLimits(cfg)
+
+	if old.Sessions != cfg.Sessions {
+		store, err := newSessionStore(cfg.Sessions)
+		if err != nil {
+			return fmt.Errorf("sessions: %w", err)
+		}
+		sessionStore.Store(store)
+	}
+
+	// 更换存储时缓存的结果不迁移，大小限制在下次写入时生效
+	if old.Cache.Backend != cfg.Cache.Backend || old.Cache.Dir != cfg.Cache.Dir {
+		cache, err := newResultCache(cfg.Cache)
+		if err != nil {
+			return fmt.Errorf("cache: %w", err)
+		}
+		toolCache.Store(cache)
+	}
+
+	return applyRoutes(old, cfg)
+}
+
+// restartRequired 列出变化了但只能在重启后生效的字段
+func restartRequired(old, cfg *Config) []string {
+	var fields []string
+	if !reflect.DeepEqual(old.Listeners, cfg.Listeners) {
+		fields = append(fields, "listeners")
+	}
+	if old.Log.Format != cfg.Log.Format {
+		fields = append(fields, "log.format")
+	}
+	if old.Tracing != cfg.Tracing {
+		fields = append(fields, "tracing")
+	}
+	if old.Quotas.Store != cfg.Quotas.Store {
+		fields = append(fields, "quotas.store")
+	}
+	return fields
+}
+
+// sameUpstream 判断两个静态路由是否指向同一个后端
+func sameUpstream(a, b *RouteConfig) bool {
+	return a.Transport == b.Transport && a.Upstream == b.Upstream &&
+		reflect.DeepEqual(a.Stdio, b.Stdio) && reflect.DeepEqual(a.Replay, b.Replay) &&
+		reflect.DeepEqual(a.Mock, b.Mock)
+}
+
+// applyRoutes 对比新旧配置中的静态路由，只替换新增、变化和删除的路由
+func applyRoutes(old, cfg *Config) error {
+	// 先启动新的本地后端，失败时不修改任何路由
+	started := map[string]localServer{}
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if prev := old.routeByPrefix(route.Prefix); prev != nil && sameUpstream(prev, route) {
+			continue
+		}
+		server, err := startLocalServer(route)
+		if err != nil {
+			for _, s := range started {
+				s.Close()
+			}
+			return fmt.Errorf("route %s: %w", route.Prefix, err)
+		}
+		if server != nil {
+			started[route.Prefix] = server
+		}
+	}
+
+	var retired []localServer
+
+	routeMapLock.Lock()
+	for i := range old.Routes {
+		prev := &old.Routes[i]
+		if route := cfg.routeByPrefix(prev.Prefix); route != nil && sameUpstream(prev, route) {
+			continue
+		}
+		// 删除或变化的路由
+		delete(routeMap, prev.Prefix)
+		delete(proxyMap, prev.Prefix)
+		delete(serverInfoMap, prev.Prefix)
+		delete(toolSchemas, prev.Prefix)
+		delete(routeMetadata, prev.Prefix)
+		if server, ok := localServers[prev.Prefix]; ok {
+			retired = append(retired, server)
+			delete(localServers, prev.Prefix)
+		}
+		logger.Info("static route removed", "route", prev.Prefix)
+	}
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if prev := old.routeByPrefix(route.Prefix); prev != nil && sameUpstream(prev, route) {
+			continue
+		}
+		target := route.Upstream
+		if server, ok := started[route.Prefix]; ok {
+			localServers[route.Prefix] = server
+			target = server.URL()
+		}
+		routeMap[route.Prefix] = target
+		delete(proxyMap, route.Prefix)
+		delete(serverInfoMap, route.Prefix)
+		delete(toolSchemas, route.Prefix)
+		delete(routeMetadata, route.Prefix)
+		logger.Info("static route added", "route", route.Prefix, "transport", route.Transport, "upstream", target)
+	}
+	currentConfig.Store(cfg)
+	routeMapLock.Unlock()
+
+	for _, server := range retired {
+		server.Close()
+	}
+	return nil
+}
+
+// watchConfig 轮询配置文件，内容变化时重载；同时响应 SIGHUP
+func watchConfig() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(configPath); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			reloadConfig("SIGHUP")
+		case <-ticker.C:
+			if configPath == "" {
+				continue
+			}
+			info, err := os.Stat(configPath)
+			if err != nil || (info.ModTime().Equal(lastMod) && info.Size() == lastSize) {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+			reloadConfig("file changed")
+		}
+	}
+}
+
+// 管理接口中间件：校验 admin.token，未配置 token 时只允许本机访问
+// 本机的反向代理转发的请求同样来自 loopback，带转发头的请求视为来自外部
+func adminMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := config().Admin.Token
+		if token == "" {
+			host, _, _ := net.SplitHostPort(r.RemoteAddr)
+			if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() || proxiedRequest(r) {
+				writeError(w, r, kindForbidden, "admin API is only available from localhost when admin.token is not set", nil)
+				return
+			}
+		} else if !adminTokenValid(r) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcp-gateway-admin"`)
+			writeError(w, r, kindUnauthorized, "invalid admin token", nil)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// proxiedRequest 判断请求是否经过代理转发
+func proxiedRequest(r *http.Request) bool {
+	for _, h := range []string{"X-Forwarded-For", "Forwarded", "X-Real-IP"} {
+		if len(r.Header.Values(h)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// adminTokenValid 判断请求是否携带了正确的 admin.token，未配置 token 时返回 false
+func adminTokenValid(r *http.Request) bool {
+	token := config().Admin.Token
+	if token == "" {
+		return false
+	}
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// AdminReload 处理 POST /admin/reload
+func AdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := reloadConfig("admin api"); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"status": "error", "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
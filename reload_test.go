@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// resetRoutes 清空路由相关的全局状态，测试结束后同样清空
+func resetRoutes(t *testing.T) {
+	reset := func() {
+		routeMapLock.Lock()
+		defer routeMapLock.Unlock()
+		routeMap = map[string]string{}
+		proxyMap = map[string]http.Handler{}
+		serverInfoMap = map[string]*ServerInfo{}
+		toolSchemas = map[string]map[string]jsonSchema{}
+		routeWeights = map[string]map[string]int{}
+		routeMetadata = map[string]*RouteMetadata{}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func routesConfig(routes ...RouteConfig) *Config {
+	cfg := defaultConfig()
+	for _, r := range routes {
+		r.Prefix, r.Transport = "/"+r.Name, "sse"
+		cfg.Routes = append(cfg.Routes, r)
+	}
+	return cfg
+}
+
+func TestApplyRoutes(t *testing.T) {
+	resetRoutes(t)
+	useConfig(t, config())
+	old := routesConfig(
+		RouteConfig{Name: "same", Upstream: "http://127.0.0.1:1/sse"},
+		RouteConfig{Name: "changed", Upstream: "http://127.0.0.1:2/sse"},
+		RouteConfig{Name: "removed", Upstream: "http://127.0.0.1:3/sse"},
+	)
+	if err := applyRoutes(&Config{}, old); err != nil {
+		t.Fatal(err)
+	}
+	// 动态注册的路由不受静态路由重载影响
+	register := httptest.NewRecorder()
+	Register(register, httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"server_name":"dynamic","server_url":"http://127.0.0.1:9/sse"}`)))
+
+	kept := http.NotFoundHandler()
+	routeMapLock.Lock()
+	for _, prefix := range []string{"/same", "/changed", "/removed"} {
+		proxyMap[prefix] = kept
+		serverInfoMap[prefix] = &ServerInfo{Type: "sse"}
+	}
+	routeMapLock.Unlock()
+
+	cfg := routesConfig(
+		RouteConfig{Name: "same", Upstream: "http://127.0.0.1:1/sse"},
+		RouteConfig{Name: "changed", Upstream: "http://127.0.0.1:20/sse"},
+		RouteConfig{Name: "added", Upstream: "http://127.0.0.1:4/sse"},
+	)
+	if err := applyRoutes(old, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	routeMapLock.RLock()
+	defer routeMapLock.RUnlock()
+	want := map[string]string{
+		"/same":    "http://127.0.0.1:1/sse",
+		"/changed": "http://127.0.0.1:20/sse",
+		"/added":   "http://127.0.0.1:4/sse",
+		"/dynamic": "http://127.0.0.1:9/sse",
+	}
+	if len(routeMap) != len(want) {
+		t.Errorf("routes = %v, want %v", routeMap, want)
+	}
+	for prefix, target := range want {
+		if routeMap[prefix] != target {
+			t.Errorf("%s -> %q, want %q", prefix, routeMap[prefix], target)
+		}
+	}
+	// 未变化的路由保留代理处理器与服务信息，变化和删除的路由清除缓存
+	if proxyMap["/same"] == nil || serverInfoMap["/same"] == nil {
+		t.Error("unchanged route lost its proxy")
+	}
+	for _, prefix := range []string{"/changed", "/removed"} {
+		if proxyMap[prefix] != nil || serverInfoMap[prefix] != nil {
+			t.Errorf("%s kept a stale proxy", prefix)
+		}
+	}
+	if config() != cfg {
+		t.Error("new config not stored")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	resetRoutes(t)
+	useConfig(t, config())
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	prevPath := configPath
+	configPath = path
+	t.Cleanup(func() { configPath = prevPath })
+
+	os.WriteFile(path, []byte("log: {level: debug}\nroutes: [{name: search, upstream: 'http://127.0.0.1:1/sse'}]\n"), 0o600)
+	if err := reloadConfig("test"); err != nil {
+		t.Fatal(err)
+	}
+	loaded := config()
+	if loaded.routeByPrefix("/search") == nil || logLevel.Level().String() != "DEBUG" {
+		t.Fatalf("reload not applied: routes %v, level %s", loaded.Routes, logLevel.Level())
+	}
+	t.Cleanup(func() { logLevel.Set(0) })
+
+	// 校验失败时保留当前配置与路由
+	os.WriteFile(path, []byte("routes: [{name: search}]\n"), 0o600)
+	if err := reloadConfig("test"); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	if config() != loaded || getRoutes()["/search"] == "" {
+		t.Error("rejected reload changed the running config")
+	}
+
+	// 监听地址要到重启后才生效，重载后仍报告正在使用的地址
+	listeners := loaded.Listeners
+	os.WriteFile(path, []byte("listeners: [{addr: ':9999'}]\nroutes: [{name: search, upstream: 'http://127.0.0.1:1/sse'}]\n"), 0o600)
+	if err := reloadConfig("test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := config().Listeners; !reflect.DeepEqual(got, listeners) {
+		t.Errorf("listeners = %v, want %v until restart", got, listeners)
+	}
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	cfg := defaultConfig()
+	useConfig(t, cfg)
+	handler := adminMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remote, auth string) int {
+		r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		r.RemoteAddr = remote
+		if auth != "" {
+			r.Header.Set("Authorization", "Bearer "+auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// 未配置 token 时只允许本机访问
+	if code := serve("127.0.0.1:5000", ""); code != http.StatusOK {
+		t.Errorf("loopback without token: %d", code)
+	}
+	if code := serve("10.0.0.8:5000", ""); code != http.StatusForbidden {
+		t.Errorf("remote without token: %d", code)
+	}
+	// 本机反向代理转发的外部请求不能免 token 访问
+	for _, h := range []string{"X-Forwarded-For", "Forwarded", "X-Real-IP"} {
+		r := httptest.NewRequest(http.MethodPost, "/admin/reload", nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set(h, "203.0.113.9")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("loopback with %s: %d", h, rec.Code)
+		}
+	}
+
+	cfg.Admin.Token = "s3cret"
+	if code := serve("10.0.0.8:5000", "s3cret"); code != http.StatusOK {
+		t.Errorf("remote with token: %d", code)
+	}
+	if code := serve("127.0.0.1:5000", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", code)
+	}
+}
@@ -1,99 +1,152 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"io"
-	"log"
-	"net/url"
-	"path/filepath"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var currentServer = getEnv("CURRENT_SERVER", "http://localhost:3000")
-
-// sseResponseModifier 用于修改 SSE 响应内容
-type sseResponseModifier struct {
-	original io.ReadCloser
-	buffer   bytes.Buffer
-	inEvent  bool
-	event    string
-	prefix   string
+// sseUpstream 读取上游 SSE 响应，改写 endpoint、观察 message 后发布到会话
+// 它由会话持有，客户端断开后仍继续读取，直到会话关闭
+type sseUpstream struct {
+	body    io.ReadCloser
+	cancel  context.CancelFunc
+	parser  sseParser
+	prefix  string
+	request *http.Request
+	session *mcpSession
+	closed  sync.Once
+	idled   atomic.Bool
 }
 
-// Read 实现 io.Reader 接口，用于拦截和修改 SSE 数据
-func (s *sseResponseModifier) Read(p []byte) (n int, err error) {
-	// 如果缓冲区中有数据，先返回缓冲区中的数据
-	if s.buffer.Len() > 0 {
-		return s.buffer.Read(p)
-	}
+// run 持续读取上游响应，直到出错或会话关闭
+func (s *sseUpstream) run() {
+	defer s.session.upstreamEnded()
 
-	// 从原始响应中读取数据
-	n, err = s.original.Read(p)
-	if n <= 0 {
-		return n, err
+	// 上游长时间没有数据时关闭连接，读取随之出错结束
+	var idle *time.Timer
+	timeout := config().SSE.UpstreamIdleTimeout
+	if timeout > 0 {
+		idle = time.AfterFunc(timeout, func() {
+			loggerFrom(s.request.Context()).Warn("upstream idle timeout", "timeout", timeout.String())
+			s.idled.Store(true)
+			s.Close()
+		})
+		defer idle.Stop()
 	}
 
-	// 处理读取到的数据
-	data := string(p[:n])
-	lines := strings.Split(data, "\n")
-	var output bytes.Buffer
-
-	for i, line := range lines {
-		trimmedLine := strings.TrimRight(line, "\r")
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := s.body.Read(buf)
+		if idle != nil {
+			idle.Reset(timeout)
+		}
+		s.process(buf[:n])
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.idled.Load() {
+				loggerFrom(s.request.Context()).Debug("upstream stream ended", "error", err)
+			}
+			return
+		}
+	}
+}
 
-		// 检测事件开始
-		if strings.HasPrefix(trimmedLine, "event:") {
-			s.event = strings.TrimSpace(strings.TrimPrefix(trimmedLine, "event:"))
-			s.inEvent = true
-			output.WriteString(trimmedLine + "\n")
-		} else if strings.HasPrefix(trimmedLine, "data:") && s.event == "endpoint" {
-			// 特殊处理 endpoint 事件的数据行
-			originalURL := strings.TrimSpace(strings.TrimPrefix(trimmedLine, "data:"))
+// process 解析一段上游数据，将处理后的完整事件发布到会话
+func (s *sseUpstream) process(raw []byte) {
+	for _, event := range s.parser.feed(raw) {
+		if s.handleEvent(&event) {
+			s.session.publish(&event)
+		}
+	}
+}
 
-			// 修改 URL，根据前缀进行替换
-			var modifiedURL string
-			if strings.HasPrefix(originalURL, "http") {
-				_url, _ := url.Parse(originalURL)
-				_url.Host = currentServer
-				modifiedURL = filepath.Join(s.prefix, _url.Path) + "?" + _url.RawQuery
-			} else {
-				modifiedURL = s.prefix + originalURL
-			}
+// handleEvent 改写 endpoint 事件并观察 message 事件，返回 false 时事件不转发给客户端
+func (s *sseUpstream) handleEvent(event *sseEvent) bool {
+	switch event.Event {
+	case "endpoint":
+		// 相对地址按上游请求解析
+		originalURL := strings.TrimSpace(event.Data)
+		endpoint, err := s.request.URL.Parse(originalURL)
+		if err != nil {
+			loggerFrom(s.request.Context()).Warn("invalid endpoint from upstream", "endpoint", originalURL, "error", err)
+			return true
+		}
 
-			log.Printf("修改 endpoint URL: %s -> %s", originalURL, modifiedURL)
-			output.WriteString("data: " + modifiedURL + "\n")
-		} else {
-			// 保持其他行不变
-			output.WriteString(trimmedLine)
-			// 只有不是最后一行时才添加换行符
-			if i < len(lines)-1 || strings.HasSuffix(data, "\n") {
-				output.WriteString("\n")
+		// 登记会话，客户端使用网关分配的会话 id；重建的会话客户端已有 endpoint，不再下发
+		if upstreamID := endpoint.Query().Get("sessionId"); upstreamID != "" {
+			s.session.register(upstreamID, endpoint.String())
+			if s.session.restored {
+				return false
 			}
+			query := endpoint.Query()
+			query.Set("sessionId", s.session.ID)
+			endpoint.RawQuery = query.Encode()
+		}
 
-			// 检测事件结束（空行）
-			if trimmedLine == "" && s.inEvent {
-				s.inEvent = false
-				s.event = ""
-			}
+		// 将 endpoint 改写为经由网关访问的公开地址；带版本的路由下发名称的地址，message 请求由 canaryRequest 按会话发往所在版本
+		modifiedURL, err := rewriteEndpoint(endpoint.String(), nil, publicBaseFrom(s.request), routeName(s.prefix))
+		if err != nil {
+			loggerFrom(s.request.Context()).Warn("invalid endpoint from upstream", "endpoint", originalURL, "error", err)
+			return true
+		}
+		loggerFrom(s.request.Context()).Debug("rewrite endpoint", "from", originalURL, "to", modifiedURL)
+		event.Data = modifiedURL
+	case "message", "":
+		// message 事件携带 JSON-RPC 响应，与会话中待响应的请求关联
+		if event.HasData {
+			return s.observeMessage(event.Data)
 		}
 	}
+	return true
+}
 
-	// 将处理后的数据写入缓冲区
-	processedData := output.Bytes()
-
-	// 如果处理后的数据长度小于等于原始缓冲区长度，直接复制
-	if len(processedData) <= len(p) {
-		copy(p, processedData)
-		return len(processedData), err
+// observeMessage 处理后端下发的 JSON-RPC 消息，网关自己发出的请求的响应返回 false
+func (s *sseUpstream) observeMessage(data string) bool {
+	msg, ok := parseJSONRPC([]byte(data))
+	if !ok {
+		return true
 	}
-
-	// 如果处理后的数据长度大于原始缓冲区，部分写入缓冲区，剩余部分保存
-	copy(p, processedData[:len(p)])
-	s.buffer.Write(processedData[len(p):])
-	return len(p), err
+	if idKey(msg.ID) != restoreRequestID {
+		s.session.cassette.record("server", []byte(data))
+	}
+	if msg.Method == "notifications/tools/list_changed" {
+		forgetTools(s.prefix)
+	}
+	if !msg.isResponse() {
+		return true
+	}
+	if idKey(msg.ID) == restoreRequestID {
+		return false
+	}
+	call := s.session.completeCall(msg)
+	if call == nil {
+		// 已超时或被客户端取消的请求，丢弃后端迟到的响应
+		return !s.session.takeCancelled(msg.ID)
+	}
+	if call.Method == "tools/list" && msg.Result != nil {
+		catalogTools(s.prefix, msg.Result)
+	}
+	endCallSpan(call.Span, msg)
+	auditor.Load().record(s.session, call, msg, len(msg.Result))
+	observeToolCall(s.session, call, msg)
+	cacheResult(s.session, call, msg)
+	compareShadow(s.session, call, msg)
+	return true
 }
 
-// Close 实现 io.Closer 接口
-func (s *sseResponseModifier) Close() error {
-	return s.original.Close()
+// Close 断开上游连接
+func (s *sseUpstream) Close() error {
+	var err error
+	s.closed.Do(func() {
+		err = s.body.Close()
+		if s.cancel != nil {
+			s.cancel()
+		}
+	})
+	return err
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var invalidArgumentsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mcp_gateway_invalid_arguments_total",
+	Help: "tools/call requests rejected because the arguments did not match the tool's inputSchema.",
+}, []string{"route", "tool"})
+
+// jsonSchema 是解析为通用结构的 JSON Schema
+type jsonSchema = map[string]any
+
+// catalogTools 从 tools/list 的结果中记录路由下各工具的 inputSchema
+// 分页返回的工具逐页合并，后端通知工具列表变化时整体清除
+func catalogTools(prefix string, result json.RawMessage) {
+	var list struct {
+		Tools []struct {
+			Name        string     `json:"name"`
+			InputSchema jsonSchema `json:"inputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(result, &list); err != nil || len(list.Tools) == 0 {
+		return
+	}
+
+	routeMapLock.Lock()
+	defer routeMapLock.Unlock()
+	schemas := toolSchemas[prefix]
+	if schemas == nil {
+		schemas = map[string]jsonSchema{}
+		toolSchemas[prefix] = schemas
+	}
+	for _, tool := range list.Tools {
+		schemas[tool.Name] = tool.InputSchema
+	}
+}
+
+// forgetTools 清除路由的工具目录
+func forgetTools(prefix string) {
+	routeMapLock.Lock()
+	defer routeMapLock.Unlock()
+	delete(toolSchemas, prefix)
+}
+
+// toolSchema 返回工具的 inputSchema，目录中没有该工具时返回 false
+func toolSchema(prefix, tool string) (jsonSchema, bool) {
+	routeMapLock.RLock()
+	defer routeMapLock.RUnlock()
+	schema, ok := toolSchemas[prefix][tool]
+	return schema, ok && schema != nil
+}
+
+// checkArguments 按目录中的 inputSchema 校验 tools/call 的参数，返回所有不符合的地方
+// 目录中没有该工具时不做校验，由后端处理
+func checkArguments(prefix string, params *toolCallParams) []string {
+	schema, ok := toolSchema(prefix, params.Name)
+	if !ok {
+		return nil
+	}
+	var args any = params.Arguments
+	if params.Arguments == nil {
+		args = map[string]any{}
+	}
+	return validateSchema(schema, args, "")
+}
+
+// validateSchema 校验 JSON 值是否符合 schema，path 为值在参数中的位置
+// 支持工具参数常用的关键字：type、enum、const、required、properties、additionalProperties、items、
+// 长度与数值范围、pattern 以及 allOf / anyOf / oneOf，不认识的关键字（如 $ref、format）被忽略
+func validateSchema(schema jsonSchema, value any, path string) []string {
+	var errs []string
+	fail := func(format string, args ...any) {
+		at := path
+		if at == "" {
+			at = "arguments"
+		}
+		errs = append(errs, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		fail("expected %s, got %s", typeNames(t), jsonType(value))
+		return errs
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return reflect.DeepEqual(e, value) }) {
+		fail("must be one of %s", compactJSON(enum))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		fail("must be %s", compactJSON(c))
+	}
+
+	switch v := value.(type) {
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := schema["minLength"].(float64); ok && n < min {
+			fail("must be at least %g characters long", min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && n > max {
+			fail("must be at most %g characters long", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re, err := compilePattern(pattern); err == nil && !re.MatchString(v) {
+				fail("must match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			fail("must be >= %g", min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			fail("must be <= %g", max)
+		}
+		if min, ok := schema["exclusiveMinimum"].(float64); ok && v <= min {
+			fail("must be > %g", min)
+		}
+		if max, ok := schema["exclusiveMaximum"].(float64); ok && v >= max {
+			fail("must be < %g", max)
+		}
+	case []any:
+		n := float64(len(v))
+		if min, ok := schema["minItems"].(float64); ok && n < min {
+			fail("must have at least %g items", min)
+		}
+		if max, ok := schema["maxItems"].(float64); ok && n > max {
+			fail("must have at most %g items", max)
+		}
+		if items, ok := schema["items"].(jsonSchema); ok {
+			for i, item := range v {
+				errs = append(errs, validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]any:
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok {
+					if _, present := v[name]; !present {
+						fail("missing required property %q", name)
+					}
+				}
+			}
+		}
+		properties, _ := schema["properties"].(jsonSchema)
+		for _, name := range slices.Sorted(maps.Keys(v)) {
+			child := name
+			if path != "" {
+				child = path + "." + name
+			}
+			if sub, ok := properties[name].(jsonSchema); ok {
+				errs = append(errs, validateSchema(sub, v[name], child)...)
+				continue
+			}
+			if _, declared := properties[name]; declared {
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property %q", name)
+				}
+			case jsonSchema:
+				errs = append(errs, validateSchema(additional, v[name], child)...)
+			}
+		}
+	}
+
+	if all, ok := schema["allOf"].([]any); ok {
+		for _, sub := range all {
+			if sub, ok := sub.(jsonSchema); ok {
+				errs = append(errs, validateSchema(sub, value, path)...)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && countMatches(anyOf, value, path) == 0 {
+		fail("must match at least one of the allowed schemas")
+	}
+	if oneOf, ok := schema["oneOf"].([]any); ok && countMatches(oneOf, value, path) != 1 {
+		fail("must match exactly one of the allowed schemas")
+	}
+	return errs
+}
+
+// countMatches 返回 value 符合的子 schema 数量
+func countMatches(schemas []any, value any, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		if sub, ok := sub.(jsonSchema); ok && len(validateSchema(sub, value, path)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// matchesType 判断值是否符合 type 关键字，type 可以是单个类型名或类型名数组
+func matchesType(t any, value any) bool {
+	switch t := t.(type) {
+	case string:
+		actual := jsonType(value)
+		return actual == t || (t == "number" && actual == "integer")
+	case []any:
+		for _, name := range t {
+			if matchesType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// jsonType 返回解码后的 JSON 值的类型名，整数值为 integer
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func typeNames(t any) string {
+	if names, ok := t.([]any); ok {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = fmt.Sprint(name)
+		}
+		return strings.Join(parts, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// 已编译的 pattern，工具的 schema 很少变化
+var patternCache sync.Map
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := patternCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patternCache.Store(pattern, re)
+	return re, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("decode %s: %v", s, err)
+	}
+	return v
+}
+
+func TestValidateSchema(t *testing.T) {
+	const schema = `{
+		"type": "object",
+		"properties": {
+			"query": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+			"limit": {"type": "integer", "minimum": 1, "maximum": 10},
+			"mode": {"enum": ["fast", "full"]},
+			"filter": {
+				"type": "object",
+				"properties": {"site": {"type": "string"}},
+				"required": ["site"],
+				"additionalProperties": false
+			},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2}
+		},
+		"required": ["query"],
+		"additionalProperties": false
+	}`
+
+	tests := []struct {
+		name string
+		args string
+		want []string
+	}{
+		{
+			name: "valid",
+			args: `{"query": "mcp", "limit": 3, "mode": "fast", "filter": {"site": "a"}, "tags": ["x"]}`,
+		},
+		{
+			name: "missing required",
+			args: `{}`,
+			want: []string{`arguments: missing required property "query"`},
+		},
+		{
+			name: "wrong type",
+			args: `{"query": 1}`,
+			want: []string{"query: expected string, got integer"},
+		},
+		{
+			name: "integer rejects fraction",
+			args: `{"query": "a", "limit": 1.5}`,
+			want: []string{"limit: expected integer, got number"},
+		},
+		{
+			name: "string length and pattern",
+			args: `{"query": "ABCDEF"}`,
+			want: []string{"query: must be at most 5 characters long", `query: must match pattern "^[a-z]+$"`},
+		},
+		{
+			name: "minimum and maximum",
+			args: `{"query": "a", "limit": 0}`,
+			want: []string{"limit: must be >= 1"},
+		},
+		{
+			name: "maximum",
+			args: `{"query": "a", "limit": 11}`,
+			want: []string{"limit: must be <= 10"},
+		},
+		{
+			name: "enum",
+			args: `{"query": "a", "mode": "slow"}`,
+			want: []string{`mode: must be one of ["fast","full"]`},
+		},
+		{
+			name: "additional properties",
+			args: `{"query": "a", "extra": true}`,
+			want: []string{`arguments: unexpected property "extra"`},
+		},
+		{
+			name: "nested object",
+			args: `{"query": "a", "filter": {"other": 1}}`,
+			want: []string{`filter: missing required property "site"`, `filter: unexpected property "other"`},
+		},
+		{
+			name: "array items",
+			args: `{"query": "a", "tags": ["x", 2, 3]}`,
+			want: []string{"tags: must have at most 2 items", "tags[1]: expected string, got integer", "tags[2]: expected string, got integer"},
+		},
+	}
+	s := decodeJSON(t, schema).(jsonSchema)
+	for _, tt := range tests {
+		got := validateSchema(s, decodeJSON(t, tt.args), "")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateSchemaCombinators(t *testing.T) {
+	s := decodeJSON(t, `{"oneOf": [{"type": "string"}, {"type": "integer"}], "anyOf": [{"const": "a"}, {"type": "integer"}]}`).(jsonSchema)
+	for _, tt := range []struct {
+		value string
+		fails bool
+	}{
+		{`"a"`, false},
+		{`3`, false},
+		{`"b"`, true},
+		{`true`, true},
+	} {
+		if errs := validateSchema(s, decodeJSON(t, tt.value), ""); (len(errs) > 0) != tt.fails {
+			t.Errorf("%s: errors %q, want fail=%v", tt.value, errs, tt.fails)
+		}
+	}
+}
+
+func TestCheckArguments(t *testing.T) {
+	resetRoutes(t)
+	catalogTools("/search", json.RawMessage(`{"tools": [{"name": "web_search", "inputSchema": {"type": "object", "required": ["query"]}}]}`))
+
+	if errs := checkArguments("/search", &toolCallParams{Name: "web_search"}); len(errs) != 1 {
+		t.Errorf("missing arguments: got %q, want one error", errs)
+	}
+	if errs := checkArguments("/search", &toolCallParams{Name: "web_search", Arguments: map[string]any{"query": "mcp"}}); errs != nil {
+		t.Errorf("valid arguments: got %q", errs)
+	}
+	// 目录中没有的工具与路由不做校验
+	if errs := checkArguments("/search", &toolCallParams{Name: "unknown"}); errs != nil {
+		t.Errorf("unknown tool: got %q", errs)
+	}
+	if errs := checkArguments("/other", &toolCallParams{Name: "web_search"}); errs != nil {
+		t.Errorf("unknown route: got %q", errs)
+	}
+
+	forgetTools("/search")
+	if errs := checkArguments("/search", &toolCallParams{Name: "web_search"}); errs != nil {
+		t.Errorf("after forgetTools: got %q", errs)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mcpSession 记录一个经过网关的 MCP SSE 会话
+// 上游 SSE 连接由会话持有，客户端连接断开后会话在恢复窗口内保留，客户端可凭 Last-Event-ID 重新接入
+// 客户端看到的是网关分配的 ID，转发到后端时替换为 UpstreamID，重建上游会话后客户端无需感知
+type mcpSession struct {
+	ID         string
+	UpstreamID string
+	Prefix     string
+	User       string
+	ClientIP   string
+	APIKey     *APIKeyConfig
+	Started    time.Time
+	Upstream   string // 后端的 SSE 地址
+
+	log *slog.Logger
+
+	// 流量统计：客户端发往后端的字节数、下发给客户端的字节数、请求数与工具调用数
+	bytesIn   atomic.Int64
+	bytesOut  atomic.Int64
+	requests  atomic.Int64
+	toolCalls atomic.Int64
+
+	// upstream 关闭后上游 SSE 连接断开，ready 在收到上游 endpoint 事件后关闭
+	upstream         io.Closer
+	upstreamEndpoint string
+	ready            chan struct{}
+	restored         bool // 由持久化记录重建的会话，不再向客户端下发 endpoint
+	closeOnce        sync.Once
+
+	// 恢复窗口与回放缓冲的事件数，创建会话时从配置读取
+	resumeWindow time.Duration
+	replayLimit  int
+
+	mu        sync.Mutex
+	pending   map[string]*pendingCall
+	cancelled map[string]time.Time // 已超时或被客户端取消的请求 id 与取消时间，后端迟到的响应会被丢弃
+
+	// 以下字段由 mu 保护
+	seq          uint64        // 最后一个事件的序号
+	replay       []replayEvent // 已发布的事件，超出回放窗口且已送达的事件会被丢弃
+	replayBytes  int           // replay 中事件的总字节数
+	delivered    uint64        // 当前客户端已读取到的序号
+	changed      chan struct{} // 有新事件或状态变化时关闭并替换，用于唤醒客户端流
+	attached     int           // 当前客户端流的代数，0 表示没有客户端连接
+	generation   int           // 最近一次接入的代数，新的接入会挤掉旧的客户端流
+	expiry       *time.Timer   // 客户端断开后的恢复窗口计时
+	terminated   bool          // 网关主动结束会话
+	upstreamDone bool          // 上游 SSE 连接已结束
+	closed       bool
+
+	// initialize 是客户端 initialize 请求的 params，重建上游会话时重放，由 mu 保护
+	initialize json.RawMessage
+
+	// cassette 录制会话的消息，路由没有开启录制时为 nil
+	cassette *cassetteRecorder
+}
+
+// replayEvent 是回放缓冲中一个已编码的 SSE 事件
+type replayEvent struct {
+	seq  uint64
+	data []byte
+}
+
+// 单个会话回放缓冲的字节上限，避免大结果占用过多内存
+const maxReplayBytes = 4 << 20
+
+// pendingCall 是已转发到后端、尚未在 SSE 流中收到结果的请求
+type pendingCall struct {
+	ID      json.RawMessage
+	Method  string
+	Tool    string
+	Args    map[string]any
+	Started time.Time
+	Span    trace.Span
+
+	timer  *time.Timer             // 超时计时，由会话的 mu 保护
+	abort  context.CancelCauseFunc // 结束仍在转发中的请求
+	shadow *shadowCall             // 镜像到影子后端的调用，没有镜像时为 nil
+
+	downgradeTo string // 超出配额后改由该降级路由完成的调用
+}
+
+// 会话表，键为 前缀 + 网关分配的会话 id（s.ID），见 sessionMapKey
+var (
+	sessionMap     = map[string]*mcpSession{}
+	sessionMapLock = sync.RWMutex{}
+)
+
+func sessionMapKey(prefix, id string) string {
+	return prefix + "|" + id
+}
+
+// newSession 在上游返回 SSE 响应时创建会话，收到 endpoint 事件后再登记到会话表
+func newSession(prefix, upstream string, r *http.Request) *mcpSession {
+	cfg := config().SSE
+	s := &mcpSession{
+		ID:        randomID(),
+		Prefix:    prefix,
+		User:      requestUser(r),
+		ClientIP:  clientIP(r),
+		APIKey:    apiKeyFrom(r.Context()),
+		Started:   time.Now(),
+		Upstream:  upstream,
+		pending:   map[string]*pendingCall{},
+		cancelled: map[string]time.Time{},
+
+		ready:        make(chan struct{}),
+		resumeWindow: cfg.ResumeWindow,
+		replayLimit:  cfg.ReplayBuffer,
+		changed:      make(chan struct{}),
+	}
+	s.log = loggerFrom(r.Context()).With("session", s.ID, "user", s.User)
+	if route := config().routeByPrefix(prefix); route != nil && route.Record != "" {
+		s.cassette = newCassetteRecorder(route.Record, route.Name, s.ID)
+	}
+	return s
+}
+
+// register 记录后端的 sessionId 与 message 端点并登记会话，message 端点的请求据此找到会话
+func (s *mcpSession) register(upstreamID, endpoint string) {
+	s.mu.Lock()
+	s.UpstreamID = upstreamID
+	s.upstreamEndpoint = endpoint
+	s.mu.Unlock()
+
+	sessionMapLock.Lock()
+	sessionMap[sessionMapKey(s.Prefix, s.ID)] = s
+	sessionMapLock.Unlock()
+	close(s.ready)
+
+	if !s.restored {
+		s.log.Info("session opened", "client_ip", s.ClientIP, "upstream_session", upstreamID)
+		sessionStore.Load().save(s.record())
+	}
+}
+
+// setInitialize 保存客户端的 initialize 参数，会话重建时用于重新初始化上游
+func (s *mcpSession) setInitialize(params json.RawMessage) {
+	s.mu.Lock()
+	s.initialize = append(json.RawMessage(nil), params...)
+	s.mu.Unlock()
+	sessionStore.Load().save(s.record())
+}
+
+// close 移除会话、删除持久化记录并关闭上游连接，可重复调用
+func (s *mcpSession) close() {
+	s.end(false)
+}
+
+// suspend 关闭会话但保留持久化记录，网关重启后客户端仍可在恢复窗口内接回
+func (s *mcpSession) suspend() {
+	s.end(true)
+}
+
+func (s *mcpSession) end(persist bool) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		if s.expiry != nil {
+			s.expiry.Stop()
+		}
+		s.broadcast()
+		registered := s.UpstreamID != ""
+		s.mu.Unlock()
+
+		if registered {
+			sessionMapLock.Lock()
+			if sessionMap[sessionMapKey(s.Prefix, s.ID)] == s {
+				delete(sessionMap, sessionMapKey(s.Prefix, s.ID))
+			}
+			sessionMapLock.Unlock()
+
+			if persist {
+				rec := s.record()
+				rec.Expires = time.Now().Add(s.resumeWindow)
+				sessionStore.Load().save(rec)
+				s.log.Info("session suspended", "duration", time.Since(s.Started).String())
+			} else {
+				sessionStore.Load().remove(s.ID)
+				s.log.Info("session closed", "duration", time.Since(s.Started).String())
+			}
+		}
+		if s.upstream != nil {
+			s.upstream.Close()
+		}
+		s.cassette.Close()
+
+		// 结束仍未收到结果的请求 span
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for key, call := range s.pending {
+			if call.timer != nil {
+				call.timer.Stop()
+			}
+			if call.Span != nil {
+				call.Span.SetStatus(codes.Error, "session closed before response")
+				call.Span.End()
+			}
+			delete(s.pending, key)
+		}
+	})
+}
+
+func lookupSession(prefix, id string) *mcpSession {
+	sessionMapLock.RLock()
+	defer sessionMapLock.RUnlock()
+	return sessionMap[sessionMapKey(prefix, id)]
+}
+
+// sessionsSnapshot 返回当前所有会话，route 非空时只返回该路由的会话
+func sessionsSnapshot(route string) []*mcpSession {
+	sessionMapLock.RLock()
+	defer sessionMapLock.RUnlock()
+
+	sessions := make([]*mcpSession, 0, len(sessionMap))
+	for _, s := range sessionMap {
+		if route == "" || s.Prefix == route {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// send 向客户端注入一个 SSE message 事件，与上游事件一起进入回放缓冲
+func (s *mcpSession) send(msg any) bool {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return false
+	}
+	s.publish(&sseEvent{Event: "message", Data: string(data), HasData: true})
+	return true
+}
+
+// notify 向客户端发送 JSON-RPC 通知
+func (s *mcpSession) notify(method string, params any) bool {
+	return s.send(map[string]any{
+		"jsonrpc": mcp.JSONRPC_VERSION,
+		"method":  method,
+		"params":  params,
+	})
+}
+
+// terminate 结束客户端的 SSE 流，已发布的事件会先写出，随后关闭会话
+func (s *mcpSession) terminate() {
+	s.mu.Lock()
+	s.terminated = true
+	s.broadcast()
+	attached := s.attached != 0
+	s.mu.Unlock()
+	if !attached {
+		s.close()
+	}
+}
+
+// kill 由管理员强制结束会话：通知后端取消未完成的请求并告知客户端，随后关闭 SSE 流与上游连接
+// SSE 传输没有显式的会话终止消息，关闭上游 SSE 连接即结束后端会话
+func (s *mcpSession) kill(reason string) {
+	s.mu.Lock()
+	var pending []json.RawMessage
+	for _, call := range s.pending {
+		pending = append(pending, call.ID)
+	}
+	s.mu.Unlock()
+
+	for _, id := range pending {
+		s.cancelUpstream(id, reason)
+	}
+	s.notify("notifications/message", map[string]any{
+		"level":  "warning",
+		"logger": "mcp-gateway",
+		"data":   reason,
+	})
+	s.log.Warn("session terminated", "reason", reason, "pending", len(pending))
+	s.terminate()
+}
+
+// inFlightCalls 返回尚未收到结果的 tools/call 数量
+func (s *mcpSession) inFlightCalls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, call := range s.pending {
+		if call.Method == "tools/call" {
+			n++
+		}
+	}
+	return n
+}
+
+// trackCall 记录一个待响应的请求，tools/call 按路由策略开始超时计时
+// abort 取消转发请求的上下文，请求超时或被客户端取消时调用
+func (s *mcpSession) trackCall(msg *jsonrpcMessage, span trace.Span, abort context.CancelCauseFunc) *pendingCall {
+	call := &pendingCall{
+		ID:      msg.ID,
+		Method:  msg.Method,
+		Started: time.Now(),
+		Span:    span,
+		abort:   abort,
+	}
+	if params := msg.toolCall(); params != nil {
+		call.Tool = params.Name
+		call.Args = params.Arguments
+		call.shadow = mirrorCall(s.Prefix, call)
+		s.toolCalls.Add(1)
+	}
+	s.requests.Add(1)
+
+	key := idKey(msg.ID)
+	s.mu.Lock()
+	s.pending[key] = call
+	if call.Method == "tools/call" {
+		if timeout := config().policyFor(s.Prefix).callTimeout(call.Tool); timeout > 0 {
+			call.timer = time.AfterFunc(timeout, func() { s.expireCall(key, call, timeout) })
+		}
+	}
+	s.mu.Unlock()
+	return call
+}
+
+// completeCall 根据响应 id 取出对应的待响应请求
+func (s *mcpSession) completeCall(msg *jsonrpcMessage) *pendingCall {
+	key := idKey(msg.ID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	call, ok := s.pending[key]
+	if !ok {
+		return nil
+	}
+	delete(s.pending, key)
+	if call.timer != nil {
+		call.timer.Stop()
+	}
+	return call
+}
+
+// requestUser 从请求中识别调用者，优先使用已认证的 API Key，其次是上游认证代理注入的头部
+// 头部与 Basic Auth 用户名由客户端任意填写，只有来自 trusted_proxies 的请求才采用
+func requestUser(r *http.Request) string {
+	if key := apiKeyFrom(r.Context()); key != nil {
+		return key.User
+	}
+	if !config().trustedProxy(r) {
+		return "anonymous"
+	}
+	for _, h := range []string{"X-Forwarded-User", "X-User"} {
+		if user := r.Header.Get(h); user != "" {
+			return user
+		}
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return "anonymous"
+}
+
+// clientIP 返回请求的客户端 IP，只有来自受信任代理的请求才采用 X-Forwarded-For，避免客户端伪造来源绕过按 IP 的限流
+func clientIP(r *http.Request) string {
+	if forwarded := forwardedValue(r, "X-Forwarded-For"); forwarded != "" && config().trustedProxy(r) {
+		return forwarded
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// randomID 生成 128 位随机十六进制 id
+func randomID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// instanceID 标识当前网关实例，多个副本共享存储时用于判断记录归属
+var instanceID = randomID()[:12]
+
+// sessionRecord 是持久化的会话信息，足以在新的上游连接上重建会话
+type sessionRecord struct {
+	ID         string          `json:"id"`
+	Route      string          `json:"route"`
+	User       string          `json:"user"`
+	ClientIP   string          `json:"client_ip"`
+	APIKeyHash string          `json:"api_key_hash,omitempty"`
+	Started    time.Time       `json:"started"`
+	Initialize json.RawMessage `json:"initialize,omitempty"`
+	Seq        uint64          `json:"seq"`
+	Owner      string          `json:"owner"`
+	Updated    time.Time       `json:"updated"`
+	Expires    time.Time       `json:"expires"` // 零值表示仍有客户端连接
+}
+
+// record 生成会话当前状态的持久化记录
+func (s *mcpSession) record() *sessionRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &sessionRecord{
+		ID:         s.ID,
+		Route:      s.Prefix,
+		User:       s.User,
+		ClientIP:   s.ClientIP,
+		APIKeyHash: hashAPIKey(s.APIKey),
+		Started:    s.Started,
+		Initialize: s.initialize,
+		Seq:        s.seq,
+		Owner:      instanceID,
+		Updated:    time.Now(),
+	}
+}
+
+func hashAPIKey(key *APIKeyConfig) string {
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key.Key))
+	return hex.EncodeToString(sum[:])
+}
+
+// 没有客户端连接、也没有写入恢复截止时间的记录（如实例崩溃）保留的最长时间
+const staleSessionAge = 24 * time.Hour
+
+// fileSessionStore 将会话记录保存为目录下的 JSON 文件，多个副本可以共享同一目录
+type fileSessionStore struct {
+	dir string
+}
+
+// 当前生效的会话存储，未配置时为 nil
+var sessionStore atomic.Pointer[fileSessionStore]
+
+// newSessionStore 创建会话存储并清理过期记录，未配置目录时返回 nil
+func newSessionStore(cfg SessionsConfig) (*fileSessionStore, error) {
+	if cfg.Store == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.Store, 0o700); err != nil {
+		return nil, err
+	}
+	st := &fileSessionStore{dir: cfg.Store}
+	st.cleanup()
+	return st, nil
+}
+
+// path 返回会话记录文件路径，id 来自客户端，只接受网关生成的十六进制 id
+func (st *fileSessionStore) path(id string) (string, bool) {
+	if id == "" || strings.Trim(id, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return filepath.Join(st.dir, id+".json"), true
+}
+
+// save 原子地写入记录，st 为 nil 时不做任何事
+func (st *fileSessionStore) save(rec *sessionRecord) {
+	if st == nil {
+		return
+	}
+	file, ok := st.path(rec.ID)
+	if !ok {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	tmp := file + "." + instanceID + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		logger.Warn("failed to save session", "session", rec.ID, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, file); err != nil {
+		logger.Warn("failed to save session", "session", rec.ID, "error", err)
+		os.Remove(tmp)
+	}
+}
+
+// load 读取记录，不存在时返回 nil
+func (st *fileSessionStore) load(id string) (*sessionRecord, error) {
+	if st == nil {
+		return nil, nil
+	}
+	file, ok := st.path(id)
+	if !ok {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rec sessionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// remove 删除本实例拥有的记录，会话已被其他副本接管时保留
+func (st *fileSessionStore) remove(id string) {
+	rec, err := st.load(id)
+	if err != nil || rec == nil || rec.Owner != instanceID {
+		return
+	}
+	if file, ok := st.path(id); ok {
+		os.Remove(file)
+	}
+}
+
+// cleanup 删除已过恢复截止时间或长期无人更新的记录
+func (st *fileSessionStore) cleanup() {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		rec, err := st.load(id)
+		if err != nil || rec == nil {
+			continue
+		}
+		if (!rec.Expires.IsZero() && now.After(rec.Expires)) || now.Sub(rec.Updated) > staleSessionAge {
+			os.Remove(filepath.Join(st.dir, entry.Name()))
+		}
+	}
+}
+
+// 重建会话时网关自己发出的 initialize 请求 id，其响应不转发给客户端
+const restoreRequestID = `"mcp-gateway-restore"`
+
+// 重建上游会话的最长等待时间
+const restoreTimeout = 10 * time.Second
+
+// restoreLocks 避免同一会话被并发重建，键为会话 id；重建可能等待 restoreTimeout，不同会话互不阻塞
+var (
+	restoreLocks     = map[string]*restoreLock{}
+	restoreLocksLock sync.Mutex
+)
+
+// restoreLock 是一个会话的重建锁，refs 为持有或等待该锁的请求数，归零时从 restoreLocks 中删除
+type restoreLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockRestore 获取会话的重建锁，返回释放锁的函数
+func lockRestore(id string) func() {
+	restoreLocksLock.Lock()
+	l := restoreLocks[id]
+	if l == nil {
+		l = &restoreLock{}
+		restoreLocks[id] = l
+	}
+	l.refs++
+	restoreLocksLock.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		restoreLocksLock.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(restoreLocks, id)
+		}
+		restoreLocksLock.Unlock()
+	}
+}
+
+// restoreSession 按持久化记录在新的上游连接上重建会话：建立 SSE 连接后重放客户端的 initialize，
+// 客户端继续使用原来的会话 id 与 message 端点。记录不存在或不可用时返回 nil
+func restoreSession(r *http.Request, id string, after uint64) (*mcpSession, error) {
+	store := sessionStore.Load()
+	if store == nil {
+		return nil, nil
+	}
+	defer lockRestore(id)()
+
+	prefix, _ := r.Context().Value(prefixKey).(string)
+	if s := lookupSession(prefix, id); s != nil {
+		return s, nil
+	}
+	rec, err := store.load(id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	switch {
+	case rec.Route != prefix:
+		return nil, nil
+	case hashAPIKey(apiKeyFrom(r.Context())) != rec.APIKeyHash:
+		return nil, errors.New("api key does not match the session")
+	case !rec.Expires.IsZero() && time.Now().After(rec.Expires):
+		return nil, errors.New("session resume window expired")
+	case rec.Initialize == nil:
+		return nil, errors.New("session was never initialized")
+	}
+	target, ok := getRoutes()[prefix]
+	if !ok {
+		return nil, nil
+	}
+
+	// 上游连接由会话持有，不随本次请求结束
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("upstream returned %s", resp.Status)
+	}
+
+	s := newSession(prefix, target, r)
+	s.ID, s.User, s.ClientIP, s.Started = rec.ID, rec.User, rec.ClientIP, rec.Started
+	s.seq = max(rec.Seq, after)
+	s.initialize = rec.Initialize
+	s.restored = true
+	s.log = loggerFrom(r.Context()).With("session", s.ID, "user", s.User)
+
+	upstream := &sseUpstream{body: resp.Body, cancel: cancel, prefix: prefix, request: req, session: s}
+	s.upstream = upstream
+	go upstream.run()
+
+	select {
+	case <-s.ready:
+	case <-time.After(restoreTimeout):
+		s.close()
+		return nil, errors.New("upstream did not send an endpoint event")
+	}
+
+	// 重放初始化握手，initialize 的响应在 SSE 流中被丢弃
+	initialize := fmt.Sprintf(`{"jsonrpc":%q,"id":%s,"method":"initialize","params":%s}`, mcp.JSONRPC_VERSION, restoreRequestID, rec.Initialize)
+	initialized := fmt.Sprintf(`{"jsonrpc":%q,"method":"notifications/initialized"}`, mcp.JSONRPC_VERSION)
+	for _, body := range []string{initialize, initialized} {
+		if err := postUpstream(ctx, s.upstreamEndpoint, body); err != nil {
+			s.close()
+			return nil, fmt.Errorf("replay initialize: %w", err)
+		}
+	}
+
+	store.save(s.record())
+	s.log.Info("session restored", "upstream_session", s.UpstreamID, "previous_owner", rec.Owner)
+	return s, nil
+}
+
+// 网关主动向后端发送消息的超时
+const upstreamPostTimeout = 10 * time.Second
+
+// postUpstream 向上游 message 端点发送一条 JSON-RPC 消息
+func postUpstream(ctx context.Context, endpoint, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, upstreamPostTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader([]byte(body)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("upstream returned %s", resp.Status)
+	}
+	return nil
+}
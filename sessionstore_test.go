@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useSessionStore 在临时目录中开启会话持久化
+func useSessionStore(t *testing.T) *fileSessionStore {
+	store, err := newSessionStore(SessionsConfig{Store: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionStore.Store(store)
+	t.Cleanup(func() { sessionStore.Store(nil) })
+	return store
+}
+
+// endpointSession 取出 endpoint 中网关分配的会话 id
+func endpointSession(t *testing.T, endpoint string) string {
+	t.Helper()
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("sessionId")
+}
+
+// switchUpstream 将路由改到新的后端，模拟网关重启后连接到全新的上游进程
+func switchUpstream(prefix, target string) {
+	routeMapLock.Lock()
+	routeMap[prefix] = target
+	delete(proxyMap, prefix)
+	routeMapLock.Unlock()
+}
+
+const testInitialize = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{},"clientInfo":{"name":"test","version":"1.0"}}}`
+
+// openSession 建立会话并完成初始化，返回网关分配的会话 id
+func openSession(t *testing.T, gw string, header http.Header) (*sseClient, string) {
+	t.Helper()
+	c := dialSSE(t, gw+"/search/sse", header)
+	id := endpointSession(t, c.waitEndpoint())
+	if code, body := c.post(testInitialize, header); code != http.StatusAccepted {
+		t.Fatalf("initialize: %d %s", code, body)
+	}
+	if msg := c.nextMessage(5 * time.Second); string(msg.ID) != "1" {
+		t.Fatalf("initialize response = %+v", msg)
+	}
+	return c, id
+}
+
+func TestRestoreSessionOnFreshUpstream(t *testing.T) {
+	resetRoutes(t)
+	resumeTestConfig(t, 100)
+	store := useSessionStore(t)
+	old := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", old.URL+"/sse")
+
+	c, id := openSession(t, gw.URL, nil)
+	lookupSession("/search", id).suspend()
+	if rec, _ := store.load(id); rec == nil || rec.Expires.IsZero() || rec.Initialize == nil {
+		t.Fatalf("suspended record = %+v", rec)
+	}
+
+	// 新的上游对原会话一无所知，网关凭记录重放初始化握手
+	fresh := newFakeUpstream(t)
+	switchUpstream("/search", fresh.URL+"/sse")
+	resumed := dialSSE(t, gw.URL+"/search/sse", http.Header{"Mcp-Session-Id": {id}})
+	resumed.endpoint = c.endpoint
+	if code, body := resumed.post(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, nil); code != http.StatusAccepted {
+		t.Fatalf("POST after restore: %d %s", code, body)
+	}
+
+	// 重放 initialize 的响应被网关吞掉，客户端看到的第一个事件就是自己请求的结果，也不会再收到 endpoint
+	event, ok := resumed.next(5 * time.Second)
+	if !ok || event.Event != "message" {
+		t.Fatalf("first event after restore = %+v, %v", event, ok)
+	}
+	if msg, _ := parseJSONRPC([]byte(event.Data)); msg == nil || string(msg.ID) != "2" {
+		t.Fatalf("first message after restore = %s, want response to id 2", event.Data)
+	}
+	if got := fmt.Sprint(fresh.methods()); got != "[initialize notifications/initialized tools/list]" {
+		t.Errorf("fresh upstream received %s", got)
+	}
+	fresh.mu.Lock()
+	params := fresh.received[0].Params
+	fresh.mu.Unlock()
+	var want struct{ Params json.RawMessage }
+	json.Unmarshal([]byte(testInitialize), &want)
+	if string(params) != string(want.Params) {
+		t.Errorf("replayed initialize params = %s, want %s", params, want.Params)
+	}
+
+	s := lookupSession("/search", id)
+	if s == nil || !s.restored || s.UpstreamID != "up-1" {
+		t.Fatalf("restored session = %+v", s)
+	}
+	if rec, _ := store.load(id); rec == nil || !rec.Expires.IsZero() || rec.Owner != instanceID {
+		t.Errorf("record after restore = %+v", rec)
+	}
+}
+
+func TestRestoreSessionRejected(t *testing.T) {
+	resetRoutes(t)
+	cfg := sseTestConfig(t)
+	cfg.SSE.ResumeWindow = 5 * time.Second
+	cfg.Auth.APIKeys = []APIKeyConfig{
+		{Key: "key-a", User: "alice"},
+		{Key: "key-b", User: "bob"},
+	}
+	store := useSessionStore(t)
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	keyA := http.Header{"X-Api-Key": {"key-a"}}
+	_, id := openSession(t, gw.URL, keyA)
+	lookupSession("/search", id).suspend()
+
+	// 其他 API Key 不能接管会话，网关改为建立新会话
+	c := dialSSE(t, gw.URL+"/search/sse", http.Header{"X-Api-Key": {"key-b"}, "Mcp-Session-Id": {id}})
+	if got := endpointSession(t, c.waitEndpoint()); got == id {
+		t.Fatal("session restored for a different api key")
+	}
+	if s := lookupSession("/search", id); s != nil {
+		t.Fatal("session restored for a different api key")
+	}
+
+	restore := func(key *APIKeyConfig) error {
+		r := httptest.NewRequest(http.MethodGet, "/sse", nil)
+		ctx := context.WithValue(r.Context(), prefixKey, "/search")
+		if key != nil {
+			ctx = context.WithValue(ctx, apiKeyKey, key)
+		}
+		s, err := restoreSession(r.WithContext(ctx), id, 0)
+		if s != nil {
+			s.close()
+			t.Fatal("session restored")
+		}
+		return err
+	}
+	if err := restore(&cfg.Auth.APIKeys[1]); err == nil || !strings.Contains(err.Error(), "api key") {
+		t.Errorf("other key: err = %v", err)
+	}
+	if err := restore(nil); err == nil || !strings.Contains(err.Error(), "api key") {
+		t.Errorf("no key: err = %v", err)
+	}
+
+	// 恢复窗口已过的记录不再接管
+	rec, _ := store.load(id)
+	rec.Expires = time.Now().Add(-time.Second)
+	store.save(rec)
+	if err := restore(&cfg.Auth.APIKeys[0]); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired: err = %v", err)
+	}
+
+	// 被拒绝的恢复不会连接上游：只有最初的会话与 key-b 的新会话
+	upstream.mu.Lock()
+	connections := upstream.next
+	upstream.mu.Unlock()
+	if connections != 2 {
+		t.Errorf("upstream connections = %d, want 2", connections)
+	}
+}
+
+func TestSessionStorePath(t *testing.T) {
+	store := &fileSessionStore{dir: t.TempDir()}
+	for _, id := range []string{"", "../etc/passwd", "ABC", "a/b"} {
+		if _, ok := store.path(id); ok {
+			t.Errorf("path(%q) accepted", id)
+		}
+	}
+	if rec, err := store.load("../x"); rec != nil || err != nil {
+		t.Errorf("load(../x) = %v, %v", rec, err)
+	}
+}
+
+func TestRestoreLockPerSession(t *testing.T) {
+	unlockA := lockRestore("a")
+
+	// 其他会话的重建不等待 a
+	done := make(chan struct{})
+	go func() {
+		lockRestore("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("restoring b waited for a")
+	}
+
+	// 同一会话的重建排队等待
+	acquired := make(chan func())
+	go func() { acquired <- lockRestore("a") }()
+	select {
+	case <-acquired:
+		t.Fatal("a locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockA()
+	(<-acquired)()
+
+	restoreLocksLock.Lock()
+	defer restoreLocksLock.Unlock()
+	if len(restoreLocks) != 0 {
+		t.Errorf("restore locks leaked: %v", restoreLocks)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var shadowRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mcp_gateway_shadow_requests_total",
+	Help: "Mirrored tools/call requests compared with the shadow upstream, by outcome (match, diverged or error).",
+}, []string{"route", "tool", "outcome"})
+
+var shadowDroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "mcp_gateway_shadow_dropped_total",
+	Help: "Sampled tools/call requests not mirrored because too many shadow calls were in flight.",
+}, []string{"route", "tool"})
+
+const (
+	defaultShadowTimeout = 30 * time.Second
+	shadowRecentLimit    = 50   // 每个工具保留的最近不一致记录数
+	shadowLatencySamples = 1000 // 每个工具用于计算延迟分位数的样本数
+	shadowSnippetLimit   = 2048 // 不一致记录中 content 的最大长度
+	shadowMaxInFlight    = 64   // 同时进行的影子调用上限，影子后端变慢时不会无限堆积
+)
+
+// shadowSlots 限制同时进行的影子调用，已满时放弃镜像
+var shadowSlots = make(chan struct{}, shadowMaxInFlight)
+
+// shadowCall 是一次镜像到影子后端的工具调用，done 关闭后 result、err、latency 可读
+type shadowCall struct {
+	done    chan struct{}
+	result  *mcp.CallToolResult
+	err     error
+	latency time.Duration
+}
+
+// mirrorCall 按路由的 shadow 配置抽样，将工具调用经影子后端的共享 MCP 客户端发出，没有抽中或被放弃时返回 nil
+func mirrorCall(prefix string, call *pendingCall) *shadowCall {
+	route := config().routeByPrefix(prefix)
+	if route == nil || route.Shadow == nil {
+		return nil
+	}
+	cfg := *route.Shadow
+	if len(cfg.Tools) > 0 && !slices.Contains(cfg.Tools, call.Tool) {
+		return nil
+	}
+	if rand.Float64()*100 >= cfg.Percent {
+		return nil
+	}
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		shadowDroppedTotal.WithLabelValues(prefix, call.Tool).Inc()
+		return nil
+	}
+
+	shadow := &shadowCall{done: make(chan struct{})}
+	go func() {
+		defer func() { <-shadowSlots }()
+		defer close(shadow.done)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+		defer cancel()
+
+		request := mcp.CallToolRequest{}
+		request.Params.Name = call.Tool
+		request.Params.Arguments = call.Args
+		started := time.Now()
+		shadow.result, shadow.err = callShared(ctx, cfg.Upstream, request)
+		shadow.latency = time.Since(started)
+	}()
+	return shadow
+}
+
+// compareShadow 在主后端返回结果后等待影子调用结束，比较两边的结果并记入报告
+func compareShadow(s *mcpSession, call *pendingCall, resp *jsonrpcMessage) {
+	if call.shadow == nil {
+		return
+	}
+	latency := time.Since(call.Started)
+	go func() {
+		<-call.shadow.done
+		diff := diffShadow(call, resp, latency)
+		diff.Session = s.ID
+		outcome := shadowReport.record(s.Prefix, call.Tool, diff)
+		shadowRequestsTotal.WithLabelValues(s.Prefix, call.Tool, outcome).Inc()
+		switch outcome {
+		case "diverged":
+			s.log.Info("shadow response diverged", "tool", call.Tool, "differences", strings.Join(diff.Differences, "; "))
+		case "error":
+			s.log.Warn("shadow call failed", "tool", call.Tool, "error", diff.ShadowError)
+		}
+	}()
+}
+
+// shadowDiff 是一次主后端与影子后端结果的对比
+type shadowDiff struct {
+	Time           time.Time      `json:"time"`
+	Session        string         `json:"session"`
+	Args           map[string]any `json:"args,omitempty"`
+	PrimaryMs      int64          `json:"primary_ms"`
+	ShadowMs       int64          `json:"shadow_ms"`
+	PrimaryIsError bool           `json:"primary_is_error"`
+	ShadowIsError  bool           `json:"shadow_is_error"`
+	Differences    []string       `json:"differences,omitempty"`
+	ShadowError    string         `json:"shadow_error,omitempty"`
+	Primary        string         `json:"primary,omitempty"` // content 不一致时两边的 content，超长时截断
+	Shadow         string         `json:"shadow,omitempty"`
+}
+
+// diffShadow 比较 isError 与 content；两边的结果都经过相同的解析，字段顺序等编码差异不算不一致
+func diffShadow(call *pendingCall, resp *jsonrpcMessage, latency time.Duration) *shadowDiff {
+	shadow := call.shadow
+	diff := &shadowDiff{
+		Time:      time.Now(),
+		Args:      auditor.Load().redact(call.Tool, call.Args),
+		PrimaryMs: latency.Milliseconds(),
+		ShadowMs:  shadow.latency.Milliseconds(),
+	}
+	if shadow.err != nil {
+		diff.ShadowError = shadow.err.Error()
+		return diff
+	}
+	diff.ShadowIsError = shadow.result.IsError
+
+	if resp.Error != nil {
+		diff.Differences = append(diff.Differences, fmt.Sprintf("primary returned error %d: %s", resp.Error.Code, resp.Error.Message))
+		return diff
+	}
+	primary, err := mcp.ParseCallToolResult(&resp.Result)
+	if err != nil {
+		diff.Differences = append(diff.Differences, fmt.Sprintf("primary returned an invalid result: %v", err))
+		return diff
+	}
+	diff.PrimaryIsError = primary.IsError
+
+	if diff.PrimaryIsError != diff.ShadowIsError {
+		diff.Differences = append(diff.Differences, fmt.Sprintf("isError: primary %t, shadow %t", diff.PrimaryIsError, diff.ShadowIsError))
+	}
+	primaryContent, _ := json.Marshal(primary.Content)
+	shadowContent, _ := json.Marshal(shadow.result.Content)
+	if string(primaryContent) != string(shadowContent) {
+		if len(primary.Content) != len(shadow.result.Content) {
+			diff.Differences = append(diff.Differences, fmt.Sprintf("content: primary %d items, shadow %d items", len(primary.Content), len(shadow.result.Content)))
+		} else {
+			diff.Differences = append(diff.Differences, "content differs")
+		}
+		diff.Primary = truncate(string(primaryContent), shadowSnippetLimit)
+		diff.Shadow = truncate(string(shadowContent), shadowSnippetLimit)
+	}
+	return diff
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// shadowStats 是一个路由下一个工具的镜像对比统计
+type shadowStats struct {
+	compared          int64
+	matched           int64
+	diverged          int64
+	shadowErrors      int64
+	isErrorMismatches int64
+	contentMismatches int64
+	primaryLatency    []time.Duration // 最近的延迟样本，只记录影子调用成功的请求
+	shadowLatency     []time.Duration
+	recent            []*shadowDiff // 最近的不一致与影子失败记录，旧的在前
+}
+
+// shadowReports 保存各路由、工具的镜像对比统计，重启后清空
+type shadowReports struct {
+	mu    sync.Mutex
+	stats map[[2]string]*shadowStats // 键为 路由前缀、工具名
+}
+
+var shadowReport = &shadowReports{stats: map[[2]string]*shadowStats{}}
+
+// record 记入一次对比，返回结果分类：match、diverged 或 error
+func (r *shadowReports) record(prefix, tool string, diff *shadowDiff) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [2]string{prefix, tool}
+	st := r.stats[key]
+	if st == nil {
+		st = &shadowStats{}
+		r.stats[key] = st
+	}
+
+	st.compared++
+	outcome := "match"
+	switch {
+	case diff.ShadowError != "":
+		st.shadowErrors++
+		outcome = "error"
+	case len(diff.Differences) > 0:
+		st.diverged++
+		outcome = "diverged"
+		if diff.PrimaryIsError != diff.ShadowIsError {
+			st.isErrorMismatches++
+		}
+		if diff.Primary != "" || diff.Shadow != "" {
+			st.contentMismatches++
+		}
+	default:
+		st.matched++
+	}
+	if outcome != "error" {
+		st.primaryLatency = appendSample(st.primaryLatency, time.Duration(diff.PrimaryMs)*time.Millisecond)
+		st.shadowLatency = appendSample(st.shadowLatency, time.Duration(diff.ShadowMs)*time.Millisecond)
+	}
+	if outcome != "match" {
+		st.recent = append(st.recent, diff)
+		if len(st.recent) > shadowRecentLimit {
+			st.recent = st.recent[len(st.recent)-shadowRecentLimit:]
+		}
+	}
+	return outcome
+}
+
+func appendSample(samples []time.Duration, d time.Duration) []time.Duration {
+	samples = append(samples, d)
+	if len(samples) > shadowLatencySamples {
+		samples = samples[len(samples)-shadowLatencySamples:]
+	}
+	return samples
+}
+
+// latencySummary 是延迟样本的平均值与分位数，单位毫秒
+type latencySummary struct {
+	AvgMs int64 `json:"avg_ms"`
+	P50Ms int64 `json:"p50_ms"`
+	P95Ms int64 `json:"p95_ms"`
+	MaxMs int64 `json:"max_ms"`
+}
+
+func summarizeLatency(samples []time.Duration) latencySummary {
+	if len(samples) == 0 {
+		return latencySummary{}
+	}
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	at := func(q float64) int64 { return sorted[int(q*float64(len(sorted)-1))].Milliseconds() }
+	return latencySummary{
+		AvgMs: (total / time.Duration(len(sorted))).Milliseconds(),
+		P50Ms: at(0.5),
+		P95Ms: at(0.95),
+		MaxMs: sorted[len(sorted)-1].Milliseconds(),
+	}
+}
+
+// shadowToolReport 是 /admin/shadow 中一个工具的对比报告
+type shadowToolReport struct {
+	Route             string         `json:"route"`
+	Tool              string         `json:"tool"`
+	Upstream          string         `json:"upstream,omitempty"` // 当前配置的影子后端，镜像已关闭时为空
+	Compared          int64          `json:"compared"`
+	Matched           int64          `json:"matched"`
+	Diverged          int64          `json:"diverged"`
+	ShadowErrors      int64          `json:"shadow_errors"`
+	IsErrorMismatches int64          `json:"is_error_mismatches"`
+	ContentMismatches int64          `json:"content_mismatches"`
+	PrimaryLatency    latencySummary `json:"primary_latency"`
+	ShadowLatency     latencySummary `json:"shadow_latency"`
+	Recent            []*shadowDiff  `json:"recent"`
+}
+
+// report 返回匹配的工具报告，route、tool 为空时不按该字段过滤
+func (r *shadowReports) report(route, tool string) []shadowToolReport {
+	cfg := config()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	reports := []shadowToolReport{}
+	for key, st := range r.stats {
+		if (route != "" && key[0] != route) || (tool != "" && key[1] != tool) {
+			continue
+		}
+		rep := shadowToolReport{
+			Route:             key[0],
+			Tool:              key[1],
+			Compared:          st.compared,
+			Matched:           st.matched,
+			Diverged:          st.diverged,
+			ShadowErrors:      st.shadowErrors,
+			IsErrorMismatches: st.isErrorMismatches,
+			ContentMismatches: st.contentMismatches,
+			PrimaryLatency:    summarizeLatency(st.primaryLatency),
+			ShadowLatency:     summarizeLatency(st.shadowLatency),
+			Recent:            append([]*shadowDiff{}, st.recent...),
+		}
+		if rc := cfg.routeByPrefix(key[0]); rc != nil && rc.Shadow != nil {
+			rep.Upstream = rc.Shadow.Upstream
+		}
+		reports = append(reports, rep)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Route != reports[j].Route {
+			return reports[i].Route < reports[j].Route
+		}
+		return reports[i].Tool < reports[j].Tool
+	})
+	return reports
+}
+
+// reset 清除匹配的统计，返回清除的工具数
+func (r *shadowReports) reset(route, tool string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for key := range r.stats {
+		if (route == "" || key[0] == route) && (tool == "" || key[1] == tool) {
+			delete(r.stats, key)
+			n++
+		}
+	}
+	return n
+}
+
+// AdminShadow 查询流量镜像的对比报告：GET 返回报告，DELETE 清除统计，route、tool 参数限定范围
+func AdminShadow(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	route := query.Get("route")
+	if route != "" {
+		route = "/" + strings.Trim(route, "/")
+	}
+	tool := query.Get("tool")
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"reports": shadowReport.report(route, tool)})
+	case http.MethodDelete:
+		n := shadowReport.reset(route, tool)
+		logger.Info("shadow reports reset", "route", route, "tool", tool, "tools", n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"reset": n})
+	default:
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shadowTestGateway 启动主后端与影子后端两个模拟服务，/translate 的调用全部镜像到影子后端
+func shadowTestGateway(t *testing.T, shadowTools string) *sseClient {
+	resetRoutes(t)
+	shadowReport.reset("/translate", "")
+	t.Cleanup(func() { shadowReport.reset("/translate", "") })
+
+	shadow, err := startMockServer("shadow", MockConfig{Tools: []MockToolConfig{
+		{Name: "translate", Response: "[{{.target}}] {{.text}}!"},
+		{Name: "echo", Response: "{{.text}}"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { shadow.Close() })
+
+	cfg, err := parseTestConfig(t, fmt.Sprintf(`
+routes:
+  - name: translate
+    prefix: /translate
+    transport: mock
+    shadow: {upstream: %q, percent: 100, tools: %s}
+    mock:
+      tools:
+        - {name: translate, response: '[{{.target}}] {{.text}}'}
+        - {name: echo, response: '{{.text}}'}
+`, shadow.URL(), shadowTools))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.SSE = SSEConfig{}
+	useConfig(t, cfg)
+	primary, err := startMockServer("translate", *cfg.Routes[0].Mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { primary.Close() })
+	gw := newTestGateway(t, "/translate", primary.URL())
+
+	c := dialSSE(t, gw.URL+"/translate/sse", nil)
+	c.waitEndpoint()
+	return c
+}
+
+// callTool 发起工具调用并等待主后端的结果
+func callTool(t *testing.T, c *sseClient, id int, tool, args string) *jsonrpcMessage {
+	t.Helper()
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"tools/call","params":{"name":%q,"arguments":%s}}`, id, tool, args)
+	if code, resp := c.post(body, nil); code != http.StatusAccepted {
+		t.Fatalf("tools/call: %d %s", code, resp)
+	}
+	return c.nextMessage(5 * time.Second)
+}
+
+// waitCompared 等待工具的对比次数达到 n
+func waitCompared(t *testing.T, tool string, n int64) shadowToolReport {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if reports := shadowReport.report("/translate", tool); len(reports) == 1 && reports[0].Compared >= n {
+			return reports[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s: shadow comparison did not finish", tool)
+	return shadowToolReport{}
+}
+
+func TestShadowCompare(t *testing.T) {
+	c := shadowTestGateway(t, "[]")
+	diverged := shadowRequestsTotal.WithLabelValues("/translate", "translate", "diverged")
+	before := metricValue(t, diverged)
+
+	// 客户端只看到主后端的结果
+	if msg := callTool(t, c, 1, "translate", `{"text":"hello","target":"zh"}`); !strings.Contains(string(msg.Result), `"text":"[zh] hello"`) {
+		t.Fatalf("primary result = %s", msg.Result)
+	}
+	callTool(t, c, 2, "echo", `{"text":"same"}`)
+
+	rep := waitCompared(t, "translate", 1)
+	if rep.Diverged != 1 || rep.ContentMismatches != 1 || len(rep.Recent) != 1 {
+		t.Fatalf("translate report = %+v", rep)
+	}
+	diff := rep.Recent[0]
+	if fmt.Sprint(diff.Differences) != "[content differs]" || !strings.Contains(diff.Shadow, "[zh] hello!") || diff.Args["text"] != "hello" {
+		t.Errorf("diff = %+v", diff)
+	}
+	if got := metricValue(t, diverged) - before; got != 1 {
+		t.Errorf("diverged = %v, want 1", got)
+	}
+	if rep := waitCompared(t, "echo", 1); rep.Matched != 1 || len(rep.Recent) != 0 {
+		t.Errorf("echo report = %+v", rep)
+	}
+
+	rec := httptest.NewRecorder()
+	AdminShadow(rec, httptest.NewRequest(http.MethodGet, "/admin/shadow?route=translate", nil))
+	var body struct{ Reports []shadowToolReport }
+	if json.Unmarshal(rec.Body.Bytes(), &body); len(body.Reports) != 2 || body.Reports[0].Tool != "echo" || body.Reports[1].Upstream == "" {
+		t.Errorf("GET /admin/shadow = %s", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	AdminShadow(rec, httptest.NewRequest(http.MethodDelete, "/admin/shadow?route=translate&tool=echo", nil))
+	if strings.TrimSpace(rec.Body.String()) != `{"reset":1}` || len(shadowReport.report("/translate", "")) != 1 {
+		t.Errorf("DELETE /admin/shadow = %s", rec.Body)
+	}
+}
+
+func TestShadowToolFilter(t *testing.T) {
+	c := shadowTestGateway(t, "[translate]")
+	callTool(t, c, 1, "echo", `{"text":"not mirrored"}`)
+	callTool(t, c, 2, "translate", `{"text":"hi","target":"en"}`)
+	waitCompared(t, "translate", 1)
+	if reports := shadowReport.report("/translate", "echo"); len(reports) != 0 {
+		t.Errorf("echo mirrored: %+v", reports)
+	}
+}
+
+func TestShadowPooledClient(t *testing.T) {
+	c := shadowTestGateway(t, "[echo]")
+	upstream := config().routeByPrefix("/translate").Shadow.Upstream
+	t.Cleanup(func() {
+		sharedClientsLock.Lock()
+		pooled := sharedClients[upstream]
+		sharedClientsLock.Unlock()
+		if pooled != nil {
+			pooled.drop()
+		}
+	})
+
+	// 多次镜像复用同一个影子客户端连接
+	callTool(t, c, 1, "echo", `{"text":"a"}`)
+	waitCompared(t, "echo", 1)
+	sharedClientsLock.Lock()
+	first := sharedClients[upstream]
+	sharedClientsLock.Unlock()
+	callTool(t, c, 2, "echo", `{"text":"b"}`)
+	waitCompared(t, "echo", 2)
+	sharedClientsLock.Lock()
+	second := sharedClients[upstream]
+	sharedClientsLock.Unlock()
+	if first == nil || first != second {
+		t.Errorf("shadow client not reused: %p, %p", first, second)
+	}
+}
+
+func TestShadowDroppedWhenFull(t *testing.T) {
+	c := shadowTestGateway(t, "[echo]")
+	dropped := shadowDroppedTotal.WithLabelValues("/translate", "echo")
+	before := metricValue(t, dropped)
+
+	// 占满影子调用的名额，新的调用不再镜像，主请求不受影响
+	for range shadowMaxInFlight {
+		shadowSlots <- struct{}{}
+	}
+	t.Cleanup(func() {
+		for range shadowMaxInFlight {
+			<-shadowSlots
+		}
+	})
+	if msg := callTool(t, c, 1, "echo", `{"text":"full"}`); !strings.Contains(string(msg.Result), `"text":"full"`) {
+		t.Fatalf("primary result = %s", msg.Result)
+	}
+	if got := metricValue(t, dropped) - before; got != 1 {
+		t.Errorf("dropped = %v, want 1", got)
+	}
+	if reports := shadowReport.report("/translate", "echo"); len(reports) != 0 {
+		t.Errorf("dropped call compared: %+v", reports)
+	}
+}
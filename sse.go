@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// sseEvent 是一个完整的 SSE 事件块
+// 字段含义见 https://html.spec.whatwg.org/multipage/server-sent-events.html
+type sseEvent struct {
+	ID       string
+	HasID    bool // 区分 "id:"（重置 last event id）与没有 id 字段
+	Event    string
+	Data     string
+	HasData  bool
+	Retry    string
+	Comments []string
+}
+
+// encode 将事件序列化为 SSE 文本，统一使用 LF 换行
+func (e *sseEvent) encode() []byte {
+	var buf bytes.Buffer
+	for _, c := range e.Comments {
+		buf.WriteString(":" + c + "\n")
+	}
+	if e.HasID {
+		writeSSEField(&buf, "id", e.ID)
+	}
+	if e.Event != "" {
+		writeSSEField(&buf, "event", e.Event)
+	}
+	if e.Retry != "" {
+		writeSSEField(&buf, "retry", e.Retry)
+	}
+	if e.HasData {
+		for _, line := range strings.Split(e.Data, "\n") {
+			writeSSEField(&buf, "data", line)
+		}
+	}
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+func writeSSEField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if value == "" {
+		buf.WriteString(":\n")
+		return
+	}
+	buf.WriteString(": ")
+	buf.WriteString(value)
+	buf.WriteString("\n")
+}
+
+// empty 判断事件块是否没有任何字段
+func (e *sseEvent) empty() bool {
+	return !e.HasID && e.Event == "" && !e.HasData && e.Retry == "" && len(e.Comments) == 0
+}
+
+// sseParser 是增量 SSE 解析器，可以按任意边界喂入数据
+// 支持 LF、CR、CRLF 换行（包括跨读取边界的 CRLF）、多行 data、注释与 BOM
+type sseParser struct {
+	line      []byte // 尚未结束的行
+	pendingCR bool   // 上一段数据以 CR 结尾，下一段开头的 LF 属于同一个换行
+	started   bool   // 是否已处理流开头的 BOM
+	current   sseEvent
+}
+
+// feed 喂入一段数据，返回其中已完整的事件
+func (p *sseParser) feed(data []byte) []sseEvent {
+	if !p.started && len(data) > 0 {
+		p.line = append(p.line, data...)
+		if len(p.line) < 3 && bytes.HasPrefix([]byte("\xEF\xBB\xBF"), p.line) {
+			return nil // BOM 可能被拆开，等待更多数据
+		}
+		p.started = true
+		data = bytes.TrimPrefix(p.line, []byte("\xEF\xBB\xBF"))
+		p.line = nil
+	}
+
+	var events []sseEvent
+	for len(data) > 0 {
+		if p.pendingCR {
+			p.pendingCR = false
+			if data[0] == '\n' {
+				data = data[1:]
+				continue
+			}
+		}
+
+		i := bytes.IndexAny(data, "\r\n")
+		if i < 0 {
+			p.line = append(p.line, data...)
+			break
+		}
+		p.line = append(p.line, data[:i]...)
+		if data[i] == '\r' {
+			p.pendingCR = true
+		}
+		data = data[i+1:]
+
+		if ev, ok := p.processLine(p.line); ok {
+			events = append(events, ev)
+		}
+		p.line = p.line[:0]
+	}
+	return events
+}
+
+// processLine 处理一行，遇到空行时返回完整事件
+func (p *sseParser) processLine(line []byte) (sseEvent, bool) {
+	if len(line) == 0 {
+		ev := p.current
+		p.current = sseEvent{}
+		return ev, !ev.empty()
+	}
+
+	if line[0] == ':' {
+		p.current.Comments = append(p.current.Comments, string(line[1:]))
+		return sseEvent{}, false
+	}
+
+	field, value := string(line), ""
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		field = string(line[:i])
+		value = strings.TrimPrefix(string(line[i+1:]), " ")
+	}
+
+	switch field {
+	case "event":
+		p.current.Event = value
+	case "data":
+		if p.current.HasData {
+			p.current.Data += "\n" + value
+		} else {
+			p.current.Data, p.current.HasData = value, true
+		}
+	case "id":
+		// 按规范忽略包含 NUL 的 id
+		if !strings.ContainsRune(value, 0) {
+			p.current.ID, p.current.HasID = value, true
+		}
+	case "retry":
+		// 只接受十进制数字
+		if value != "" && strings.Trim(value, "0123456789") == "" {
+			p.current.Retry = value
+		}
+	}
+	// 其他字段按规范忽略
+	return sseEvent{}, false
+}
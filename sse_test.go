@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func parseChunks(chunks ...string) []sseEvent {
+	var p sseParser
+	var events []sseEvent
+	for _, c := range chunks {
+		events = append(events, p.feed([]byte(c))...)
+	}
+	return events
+}
+
+func TestSSEParser(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []sseEvent
+	}{
+		{
+			name:   "line split across reads",
+			chunks: []string{"event: endpoint\nda", "ta: /message?sessionId=1\n", "\n"},
+			want:   []sseEvent{{Event: "endpoint", Data: "/message?sessionId=1", HasData: true}},
+		},
+		{
+			name:   "crlf split across reads",
+			chunks: []string{"event: message\r", "\ndata: {}\r\n\r", "\n"},
+			want:   []sseEvent{{Event: "message", Data: "{}", HasData: true}},
+		},
+		{
+			name:   "bare cr line endings",
+			chunks: []string{"data: a\rdata: b\r\r"},
+			want:   []sseEvent{{Data: "a\nb", HasData: true}},
+		},
+		{
+			name:   "multi-line data, id, retry and comments",
+			chunks: []string{": keepalive\nid: 7\nretry: 3000\ndata: a\ndata:\ndata:  b\n\n"},
+			want: []sseEvent{{
+				ID: "7", HasID: true, Retry: "3000", Data: "a\n\n b", HasData: true,
+				Comments: []string{" keepalive"},
+			}},
+		},
+		{
+			name:   "comment only event",
+			chunks: []string{":ping\n\n"},
+			want:   []sseEvent{{Comments: []string{"ping"}}},
+		},
+		{
+			name:   "bom split across reads",
+			chunks: []string{"\xEF\xBB", "\xBFdata: x\n\n"},
+			want:   []sseEvent{{Data: "x", HasData: true}},
+		},
+		{
+			name:   "invalid retry and unknown fields ignored",
+			chunks: []string{"retry: soon\nfoo: bar\n\n"},
+			want:   nil,
+		},
+		{
+			name:   "incomplete trailing event is not dispatched",
+			chunks: []string{"data: a\n\ndata: b\n"},
+			want:   []sseEvent{{Data: "a", HasData: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseChunks(tt.chunks...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// FuzzSSEParser 校验任意切分方式得到的事件与一次性解析一致，且序列化后可以无损解析回来
+func FuzzSSEParser(f *testing.F) {
+	f.Add([]byte("event: endpoint\r\ndata: http://localhost:8080/message?sessionId=1\r\n\r\n"), uint(7))
+	f.Add([]byte("event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n"), uint(1))
+	f.Add([]byte(": ping\r\rid: 1\nretry: 10\ndata: a\ndata: b\n\n"), uint(2))
+	f.Add([]byte("\xEF\xBB\xBFdata\n\n"), uint(1))
+
+	f.Fuzz(func(t *testing.T, data []byte, size uint) {
+		var whole sseParser
+		want := whole.feed(data)
+
+		chunk := int(size%16) + 1
+		var split sseParser
+		var got []sseEvent
+		for rest := data; len(rest) > 0; {
+			n := min(chunk, len(rest))
+			got = append(got, split.feed(rest[:n])...)
+			rest = rest[n:]
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("chunked parse differs:\n got %#v\nwant %#v", got, want)
+		}
+
+		var encoded bytes.Buffer
+		for _, ev := range want {
+			encoded.Write(ev.encode())
+		}
+		var again sseParser
+		if round := again.feed(encoded.Bytes()); !reflect.DeepEqual(round, want) {
+			t.Fatalf("round trip differs:\n got %#v\nwant %#v\nencoded %q", round, want, encoded.Bytes())
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// stdioBridge 将 stdio MCP 服务包装为本地回环地址上的 SSE 服务，
+// 网关像代理普通 SSE 后端一样代理它，审计、指标、追踪等逻辑保持一致
+type stdioBridge struct {
+	name   string
+	cfg    StdioConfig
+	server *http.Server
+	addr   string
+
+	mu       sync.Mutex
+	sessions map[string]*stdioSession
+}
+
+// stdioSession 对应一个 SSE 连接及其启动的子进程
+type stdioSession struct {
+	stdin io.WriteCloser
+	mu    sync.Mutex
+}
+
+// startStdioBridge 在 127.0.0.1 的随机端口上启动桥接服务
+func startStdioBridge(name string, cfg StdioConfig) (*stdioBridge, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &stdioBridge{
+		name:     name,
+		cfg:      cfg,
+		addr:     ln.Addr().String(),
+		sessions: map[string]*stdioSession{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", b.handleSSE)
+	mux.HandleFunc("/message", b.handleMessage)
+	b.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := b.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("stdio bridge stopped", "server", name, "error", err)
+		}
+	}()
+	return b, nil
+}
+
+// URL 返回桥接服务的 SSE 地址，作为路由目标
+func (b *stdioBridge) URL() string {
+	return "http://" + b.addr + "/sse"
+}
+
+// Close 关闭桥接服务，请求上下文取消后子进程随之退出
+func (b *stdioBridge) Close() error {
+	return b.server.Close()
+}
+
+func (b *stdioBridge) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, kindInternal, "streaming unsupported", nil)
+		return
+	}
+
+	log := logger.With("server", b.name)
+	cmd := exec.CommandContext(r.Context(), b.cfg.Command, b.cfg.Args...)
+	cmd.Dir = b.cfg.Dir
+	cmd.Env = os.Environ()
+	for k, v := range b.cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		writeError(w, r, kindInternal, "failed to start stdio server: "+err.Error(), nil)
+		return
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		writeError(w, r, kindInternal, "failed to start stdio server: "+err.Error(), nil)
+		return
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		writeError(w, r, kindInternal, "failed to start stdio server: "+err.Error(), nil)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		log.Error("failed to start stdio server", "command", b.cfg.Command, "error", err)
+		writeError(w, r, kindUpstreamUnavailable, "failed to start stdio server", nil)
+		return
+	}
+	defer cmd.Wait()
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Debug("stdio server stderr", "line", scanner.Text())
+		}
+	}()
+
+	sessionID := randomID()
+	b.mu.Lock()
+	b.sessions[sessionID] = &stdioSession{stdin: stdin}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.sessions, sessionID)
+		b.mu.Unlock()
+		stdin.Close()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "event: endpoint\ndata: http://%s/message?sessionId=%s\n\n", b.addr, sessionID)
+	flusher.Flush()
+
+	// stdio 传输中每行是一条 JSON-RPC 消息
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", line)
+			flusher.Flush()
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Warn("stdio server output error", "error", err)
+			}
+			return
+		}
+	}
+}
+
+func (b *stdioBridge) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, kindMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	b.mu.Lock()
+	session := b.sessions[r.URL.Query().Get("sessionId")]
+	b.mu.Unlock()
+	if session == nil {
+		writeError(w, r, kindNotFound, "invalid session ID", map[string]any{"session": r.URL.Query().Get("sessionId")})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageBodySize))
+	if err != nil {
+		writeError(w, r, kindBadRequest, "failed to read request body", nil)
+		return
+	}
+	// 消息必须压缩为单行
+	var line bytes.Buffer
+	if err := json.Compact(&line, body); err != nil {
+		writeError(w, r, kindParseError, "parse error: request body is not valid JSON-RPC", nil)
+		return
+	}
+	line.WriteByte('\n')
+
+	session.mu.Lock()
+	_, err = session.stdin.Write(line.Bytes())
+	session.mu.Unlock()
+	if err != nil {
+		writeError(w, r, kindUpstreamUnavailable, "stdio server is not running", nil)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// broadcast 唤醒等待中的客户端流，调用方需持有 s.mu
+func (s *mcpSession) broadcast() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// publish 为事件分配序号并写入回放缓冲
+// 开启恢复时事件 id 为 "sessionId:序号"，客户端重连时通过 Last-Event-ID 带回
+func (s *mcpSession) publish(event *sseEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	if s.resumeWindow > 0 {
+		event.ID, event.HasID = s.ID+":"+strconv.FormatUint(s.seq, 10), true
+	}
+	data := event.encode()
+	s.bytesOut.Add(int64(len(data)))
+	s.replay = append(s.replay, replayEvent{seq: s.seq, data: data})
+	s.replayBytes += len(data)
+
+	// 只丢弃客户端已读取的事件，未送达的事件无论多少都保留
+	for len(s.replay) > 0 && s.replay[0].seq <= s.delivered &&
+		(len(s.replay) > s.replayLimit || s.replayBytes > maxReplayBytes) {
+		s.replayBytes -= len(s.replay[0].data)
+		s.replay = s.replay[1:]
+	}
+	s.broadcast()
+}
+
+// next 返回序号 after 之后的事件；没有新事件时返回等待通道，ended 表示流应当结束
+func (s *mcpSession) next(generation int, after uint64) (data []byte, last uint64, wait <-chan struct{}, ended bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if generation != s.attached {
+		return nil, after, nil, true
+	}
+	var buf bytes.Buffer
+	last = after
+	for _, ev := range s.replay {
+		if ev.seq > after {
+			buf.Write(ev.data)
+			last = ev.seq
+		}
+	}
+	s.delivered = last
+	if buf.Len() > 0 {
+		return buf.Bytes(), last, nil, false
+	}
+	return nil, last, s.changed, s.terminated || s.upstreamDone || s.closed
+}
+
+// attach 接入一个客户端流，after 为客户端已收到的最后一个序号
+func (s *mcpSession) attach(after uint64) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.terminated {
+		return 0, false
+	}
+	if s.expiry != nil {
+		s.expiry.Stop()
+		s.expiry = nil
+	}
+	if after > 0 && len(s.replay) > 0 && s.replay[0].seq > after+1 {
+		s.log.Warn("replay buffer no longer holds all missed events", "last_event", after, "oldest", s.replay[0].seq)
+	}
+	s.generation++
+	s.attached = s.generation
+	s.delivered = after
+	s.broadcast()
+	return s.generation, true
+}
+
+// position 返回当前客户端已读取到的序号，按会话 id 接回且没有 Last-Event-ID 时从这里继续
+func (s *mcpSession) position() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delivered
+}
+
+// detach 在客户端流结束时调用，恢复窗口内保留会话与上游连接
+func (s *mcpSession) detach(generation int) {
+	s.mu.Lock()
+	if generation != s.attached || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.attached = 0
+	if s.terminated || s.upstreamDone || s.resumeWindow <= 0 || s.UpstreamID == "" {
+		s.mu.Unlock()
+		s.close()
+		return
+	}
+	s.expiry = time.AfterFunc(s.resumeWindow, func() {
+		s.log.Info("session resume window expired")
+		s.close()
+	})
+	s.mu.Unlock()
+	s.log.Info("client disconnected, keeping session for resume", "window", s.resumeWindow.String())
+
+	// 记录恢复截止时间，其他副本或重启后的网关据此判断能否接管
+	rec := s.record()
+	rec.Expires = time.Now().Add(s.resumeWindow)
+	sessionStore.Load().save(rec)
+}
+
+// upstreamEnded 在上游 SSE 连接结束时调用，客户端读完剩余事件后流随之结束
+func (s *mcpSession) upstreamEnded() {
+	s.mu.Lock()
+	s.upstreamDone = true
+	s.broadcast()
+	attached := s.attached != 0
+	s.mu.Unlock()
+	if !attached {
+		s.close()
+	}
+}
+
+// sseClientStream 是一个客户端 SSE 连接读取会话事件的视图，作为响应体交给 ReverseProxy 或直接写出
+type sseClientStream struct {
+	session    *mcpSession
+	ctx        context.Context // 客户端请求的上下文，客户端断开后流结束
+	generation int
+	last       uint64
+	buffer     bytes.Buffer
+	closed     sync.Once
+
+	// keepalive 在流空闲时触发注释保活
+	keepAliveInterval time.Duration
+	keepalive         *time.Timer
+}
+
+// sseKeepAlive 是注入的保活注释，客户端解析时会忽略
+var sseKeepAlive = []byte(": keepalive\n\n")
+
+// newClientStream 将客户端接入会话，会话已关闭时返回 false
+func newClientStream(ctx context.Context, session *mcpSession, after uint64) (*sseClientStream, bool) {
+	generation, ok := session.attach(after)
+	if !ok {
+		return nil, false
+	}
+	c := &sseClientStream{
+		session:           session,
+		ctx:               ctx,
+		generation:        generation,
+		last:              after,
+		keepAliveInterval: config().SSE.KeepAlive,
+	}
+	if c.keepAliveInterval > 0 {
+		c.keepalive = time.NewTimer(c.keepAliveInterval)
+	}
+	if generation > 1 {
+		// 客户端接回后会话重新归本实例所有，清除恢复截止时间
+		sessionStore.Load().save(session.record())
+	}
+	sseConnections.WithLabelValues(session.Prefix).Inc()
+	return c, true
+}
+
+// Read 实现 io.Reader 接口，缓冲区中始终是完整的事件
+func (c *sseClientStream) Read(p []byte) (int, error) {
+	for c.buffer.Len() == 0 {
+		data, last, wait, ended := c.session.next(c.generation, c.last)
+		if len(data) > 0 {
+			c.buffer.Write(data)
+			c.last = last
+			break
+		}
+		if ended {
+			return 0, io.EOF
+		}
+
+		var keepalive <-chan time.Time
+		if c.keepalive != nil {
+			keepalive = c.keepalive.C
+		}
+		select {
+		case <-wait:
+		case <-keepalive:
+			c.buffer.Write(sseKeepAlive)
+		case <-c.ctx.Done():
+			// 客户端已断开，正常结束响应，会话留待恢复
+			return 0, io.EOF
+		}
+	}
+
+	// 有数据写出后重新计算空闲时间
+	if c.keepalive != nil {
+		c.keepalive.Reset(c.keepAliveInterval)
+	}
+	return c.buffer.Read(p)
+}
+
+// Close 实现 io.Closer 接口，客户端流结束时与会话分离
+func (c *sseClientStream) Close() error {
+	c.closed.Do(func() {
+		if c.keepalive != nil {
+			c.keepalive.Stop()
+		}
+		c.session.detach(c.generation)
+		sseConnections.WithLabelValues(c.session.Prefix).Dec()
+	})
+	return nil
+}
+
+// parseLastEventID 解析网关分配的事件 id "sessionId:序号"
+func parseLastEventID(id string) (string, uint64, bool) {
+	i := strings.LastIndexByte(id, ':')
+	if i <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return id[:i], seq, true
+}
+
+// reattachTarget 返回客户端要接回的会话 id 与已收到的最后序号
+// Last-Event-ID 同时携带两者；Mcp-Session-Id 只指定会话，hasSeq 为 false
+func reattachTarget(r *http.Request) (id string, after uint64, hasSeq bool) {
+	if id, seq, ok := parseLastEventID(r.Header.Get("Last-Event-ID")); ok {
+		return id, seq, true
+	}
+	return r.Header.Get("Mcp-Session-Id"), 0, false
+}
+
+// resumableSession 返回客户端要接回的会话及回放起点，本实例没有该会话时尝试从持久化记录重建
+// API Key 与建立会话时不一致时视为不存在
+func resumableSession(r *http.Request) (*mcpSession, uint64) {
+	id, after, hasSeq := reattachTarget(r)
+	if id == "" {
+		return nil, 0
+	}
+	prefix, _ := r.Context().Value(prefixKey).(string)
+	s := lookupSession(prefix, id)
+	if s == nil {
+		restored, err := restoreSession(r, id, after)
+		if err != nil {
+			loggerFrom(r.Context()).Warn("session restore failed", "session", id, "error", err)
+		}
+		if restored == nil {
+			return nil, 0
+		}
+		s = restored
+	} else if key := apiKeyFrom(r.Context()); (key == nil) != (s.APIKey == nil) || (key != nil && key.Key != s.APIKey.Key) {
+		return nil, 0
+	}
+	if !hasSeq {
+		after = s.position()
+	}
+	return s, after
+}
+
+// streamContextKey 保存新建 SSE 连接的客户端上下文
+const streamContextKey contextKey = "streamContext"
+
+// streamContext 让上游 SSE 请求不随客户端断开而取消，会话接管后由会话负责结束上游连接
+type streamContext struct {
+	client context.Context
+	cancel context.CancelFunc
+	owned  bool
+}
+
+// streamFrom 取出请求上的 streamContext，不是新建的 SSE 连接时返回 nil
+func streamFrom(ctx context.Context) *streamContext {
+	sc, _ := ctx.Value(streamContextKey).(*streamContext)
+	return sc
+}
+
+// SSE 流中间件：带 Last-Event-ID 或 Mcp-Session-Id 的重连由网关直接从会话回放，
+// 新建连接的上游请求与客户端连接解耦，使会话可以在客户端断开后继续接收上游事件
+func streamMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Query().Get("sessionId") != "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if session, after := resumableSession(r); session != nil {
+			if stream, ok := newClientStream(r.Context(), session, after); ok {
+				loggerFrom(r.Context()).Info("session resumed", "session", session.ID, "last_event", after)
+				serveStream(w, stream)
+				return
+			}
+		}
+
+		sc := &streamContext{client: r.Context()}
+		ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+		sc.cancel = cancel
+		r = r.WithContext(context.WithValue(ctx, streamContextKey, sc))
+		handler.ServeHTTP(w, r)
+		if !sc.owned {
+			cancel()
+		}
+	})
+}
+
+// serveStream 将客户端流写出到响应，直到流结束或客户端断开
+func serveStream(w http.ResponseWriter, stream *sseClientStream) {
+	defer stream.Close()
+
+	setCORSHeaders(w.Header())
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	rc.Flush()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// resumeTestConfig 开启恢复窗口，回放缓冲保留 replay 个已送达的事件
+func resumeTestConfig(t *testing.T, replay int) {
+	cfg := sseTestConfig(t)
+	cfg.SSE.ResumeWindow = 5 * time.Second
+	cfg.SSE.ReplayBuffer = replay
+}
+
+// notification 是后端推送给会话的第 n 条通知
+func notification(n int) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "method": "notifications/progress", "params": map[string]any{"n": n}}
+}
+
+// readNotifications 读取 count 个 message 事件，返回事件 id 与通知序号
+func readNotifications(t *testing.T, c *sseClient, count int) (ids []string, ns []int) {
+	t.Helper()
+	for len(ns) < count {
+		event, ok := c.next(5 * time.Second)
+		if !ok {
+			t.Fatalf("stream ended after %d of %d notifications", len(ns), count)
+		}
+		if event.Event != "message" {
+			continue
+		}
+		var n int
+		if _, err := fmt.Sscanf(event.Data, `{"jsonrpc":"2.0","method":"notifications/progress","params":{"n":%d}}`, &n); err != nil {
+			t.Fatalf("unexpected message %q", event.Data)
+		}
+		ids = append(ids, event.ID)
+		ns = append(ns, n)
+	}
+	return ids, ns
+}
+
+// waitPublished 等待会话发布到第 seq 个事件
+func waitPublished(t *testing.T, prefix, id string, seq uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := lookupSession(prefix, id); s != nil {
+			s.mu.Lock()
+			done := s.seq >= seq
+			s.mu.Unlock()
+			if done {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s did not publish event %d", id, seq)
+}
+
+// waitDetached 等待会话的客户端流分离
+func waitDetached(t *testing.T, prefix, id string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := lookupSession(prefix, id); s != nil {
+			s.mu.Lock()
+			detached := s.attached == 0
+			s.mu.Unlock()
+			if detached {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("session %s still attached", id)
+}
+
+// dropAndResume 建立会话并收到 5 条通知后断开，断开期间后端再推送 2 条，
+// 返回会话 id、客户端收到的事件 id，以及 endpoint 事件的 id
+func dropAndResume(t *testing.T, upstream *fakeUpstream, gw string) (string, []string, string) {
+	t.Helper()
+	c := dialSSE(t, gw+"/search/sse", nil)
+	c.waitEndpoint()
+	upstream.mu.Lock()
+	upstreamID := fmt.Sprintf("up-%d", upstream.next)
+	upstream.mu.Unlock()
+
+	for n := 1; n <= 5; n++ {
+		upstream.send(upstreamID, notification(n))
+	}
+	ids, ns := readNotifications(t, c, 5)
+	if fmt.Sprint(ns) != "[1 2 3 4 5]" {
+		t.Fatalf("live notifications = %v", ns)
+	}
+	sessionID, first, ok := parseLastEventID(ids[0])
+	if !ok || first != 2 {
+		t.Fatalf("event id %q, want <session>:2", ids[0])
+	}
+
+	c.close()
+	waitDetached(t, "/search", sessionID)
+	upstream.send(upstreamID, notification(6))
+	upstream.send(upstreamID, notification(7))
+	// endpoint 为第 1 个事件，7 条通知为第 2 到第 8 个事件
+	waitPublished(t, "/search", sessionID, 8)
+	return sessionID, ids, sessionID + ":1"
+}
+
+func TestResumeReplaysMissedEvents(t *testing.T) {
+	resetRoutes(t)
+	resumeTestConfig(t, 100)
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	_, ids, _ := dropAndResume(t, upstream, gw.URL)
+	c := dialSSE(t, gw.URL+"/search/sse", http.Header{"Last-Event-Id": {ids[len(ids)-1]}})
+	// 只回放断开期间的事件，不重复已收到的事件，也不再下发 endpoint
+	var replayed []string
+	for {
+		event, ok := c.next(300 * time.Millisecond)
+		if !ok {
+			break
+		}
+		replayed = append(replayed, event.Event+" "+event.Data)
+	}
+	want := []string{
+		`message {"jsonrpc":"2.0","method":"notifications/progress","params":{"n":6}}`,
+		`message {"jsonrpc":"2.0","method":"notifications/progress","params":{"n":7}}`,
+	}
+	if fmt.Sprint(replayed) != fmt.Sprint(want) {
+		t.Errorf("replayed events = %q, want %q", replayed, want)
+	}
+}
+
+func TestResumeSkipsEventsOlderThanBuffer(t *testing.T) {
+	resetRoutes(t)
+	resumeTestConfig(t, 2)
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	sessionID, _, endpointID := dropAndResume(t, upstream, gw.URL)
+	s := lookupSession("/search", sessionID)
+	s.mu.Lock()
+	oldest := s.replay[0].seq
+	s.mu.Unlock()
+	if oldest != 7 {
+		t.Fatalf("oldest buffered event = %d, want 7", oldest)
+	}
+
+	// 从 endpoint 之后恢复：已被丢弃的第 2 到 6 个事件无法回放，未送达的事件全部保留
+	c := dialSSE(t, gw.URL+"/search/sse", http.Header{"Last-Event-Id": {endpointID}})
+	_, ns := readNotifications(t, c, 2)
+	if fmt.Sprint(ns) != "[6 7]" {
+		t.Errorf("replayed notifications = %v, want [6 7]", ns)
+	}
+}
+
+func TestResumeUnknownSession(t *testing.T) {
+	resetRoutes(t)
+	resumeTestConfig(t, 100)
+	upstream := newFakeUpstream(t)
+	gw := newTestGateway(t, "/search", upstream.URL+"/sse")
+
+	// 无法识别的 Last-Event-ID 建立新会话
+	c := dialSSE(t, gw.URL+"/search/sse", http.Header{"Last-Event-Id": {"missing:3"}})
+	c.waitEndpoint()
+	for _, id := range []string{"", "nocolon", "s:x", ":1"} {
+		if _, _, ok := parseLastEventID(id); ok {
+			t.Errorf("parseLastEventID(%q) accepted", id)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/daodao97/mcp-gateway")
+
+// initTracing 根据配置初始化链路追踪
+// 支持 none（默认）、stdout、otlp；otlp 的地址等参数使用标准 OTEL_EXPORTER_OTLP_* 环境变量
+func initTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("opentelemetry error", "error", err)
+	}))
+	// 无论是否导出，都按 W3C trace-context 透传上游请求
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := cfg.Exporter; name {
+	case "none", "":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(getEnv("OTEL_SERVICE_NAME", "mcp-gateway")),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// startCallSpan 为客户端发出的 JSON-RPC 请求创建 span，在 SSE 返回结果时结束
+func startCallSpan(ctx context.Context, prefix string, msg *jsonrpcMessage) trace.Span {
+	attrs := []attribute.KeyValue{
+		semconv.RPCSystemKey.String("jsonrpc"),
+		semconv.RPCMethod(msg.Method),
+		semconv.RPCJsonrpcRequestID(string(msg.ID)),
+		attribute.String("mcp.route", prefix),
+	}
+	if params := msg.toolCall(); params != nil {
+		attrs = append(attrs, attribute.String("mcp.tool", params.Name))
+	}
+	_, span := tracer.Start(ctx, "jsonrpc "+msg.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return span
+}
+
+// endCallSpan 根据响应结束请求 span
+func endCallSpan(span trace.Span, resp *jsonrpcMessage) {
+	if span == nil {
+		return
+	}
+	if resp.Error != nil {
+		span.SetAttributes(semconv.RPCJsonrpcErrorCode(resp.Error.Code))
+		span.SetStatus(codes.Error, resp.Error.Message)
+	} else if resp.isToolError() {
+		span.SetStatus(codes.Error, "tool returned isError")
+	}
+	span.End()
+}
+
+// injectTraceMeta 将 span 的 trace-context 写入 params._meta，供后端继续链路
+// 没有有效 span 或请求没有 params 对象时返回原始消息
+func injectTraceMeta(body []byte, span trace.Span) []byte {
+	if span == nil || !span.SpanContext().IsValid() {
+		return body
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return body
+	}
+	// 只解析到第一层，保持参数原样（避免数字精度变化）
+	params := map[string]json.RawMessage{}
+	if p, ok := raw["params"]; ok {
+		if err := json.Unmarshal(p, &params); err != nil {
+			return body
+		}
+	}
+	meta := map[string]any{}
+	if m, ok := params["_meta"]; ok {
+		if err := json.Unmarshal(m, &meta); err != nil {
+			return body
+		}
+	}
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(trace.ContextWithSpan(context.Background(), span), carrier)
+	for k, v := range carrier {
+		meta[k] = v
+	}
+	encodedMeta, err := json.Marshal(meta)
+	if err != nil {
+		return body
+	}
+	params["_meta"] = encodedMeta
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return body
+	}
+	raw["params"] = encoded
+	rewritten, err := json.Marshal(raw)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
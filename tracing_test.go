@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// 全局 tracer 只会委托给第一次设置的 TracerProvider，测试共用同一个记录器
+var (
+	spanRecorder     = tracetest.NewSpanRecorder()
+	spanRecorderOnce sync.Once
+)
+
+func recordSpans() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spanRecorder
+}
+
+func TestCallSpan(t *testing.T) {
+	recorder := recordSpans()
+	ended := len(recorder.Ended())
+
+	var msg jsonrpcMessage
+	json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"web_search","arguments":{"n":12345678901234567890},"_meta":{"progressToken":"p1"}}}`), &msg)
+	span := startCallSpan(context.Background(), "/search", &msg)
+
+	// trace-context 写入 params._meta，保留已有字段与参数原样
+	body, _ := json.Marshal(msg)
+	var injected struct {
+		Params struct {
+			Arguments json.RawMessage   `json:"arguments"`
+			Meta      map[string]string `json:"_meta"`
+		} `json:"params"`
+	}
+	if err := json.Unmarshal(injectTraceMeta(body, span), &injected); err != nil {
+		t.Fatal(err)
+	}
+	if injected.Params.Meta["progressToken"] != "p1" || injected.Params.Meta["traceparent"] == "" {
+		t.Errorf("_meta = %v", injected.Params.Meta)
+	}
+	if got := string(injected.Params.Arguments); got != `{"n":12345678901234567890}` {
+		t.Errorf("arguments = %s", got)
+	}
+
+	endCallSpan(span, &jsonrpcMessage{Error: &jsonrpcError{Code: -32602, Message: "invalid params"}})
+	spans := recorder.Ended()[ended:]
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	got := spans[0]
+	if got.Name() != "jsonrpc tools/call" || got.Status().Code != codes.Error {
+		t.Errorf("span %s status %v", got.Name(), got.Status())
+	}
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range got.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if attrs["mcp.tool"].AsString() != "web_search" || attrs["mcp.route"].AsString() != "/search" || attrs["rpc.jsonrpc.error_code"].AsInt64() != -32602 {
+		t.Errorf("attributes = %v", attrs)
+	}
+
+	// 没有有效 span 时不修改消息
+	if out := injectTraceMeta(body, nil); string(out) != string(body) {
+		t.Errorf("message rewritten without span: %s", out)
+	}
+}